@@ -0,0 +1,138 @@
+// Package carserver serves car files generated on demand from their Carv1Ref
+// and the blocks they are made of, so that no car file is written to disk.
+package carserver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+)
+
+var log = logging.Logger("filehelper/carserver")
+
+type car struct {
+	// vc is shared by the requests for the car, each reads it through its own section reader
+	vc    *carv1.VirtualCar
+	etag  string
+	ctype string
+}
+
+// Server is an http.Handler serving the cars added to it. GET /<payload cid>
+// returns the car whose first root is the payload cid, GET /<piece cid> the
+// same car padded to its piece size. A ".car" suffix is accepted. Range,
+// HEAD and conditional requests are handled by http.ServeContent.
+type Server struct {
+	lk   sync.RWMutex
+	cars map[cid.Cid]*car
+}
+
+func New() *Server {
+	return &Server{
+		cars: make(map[cid.Cid]*car),
+	}
+}
+
+// Add serves the car of ref by its payload cid, and by pieceCid when it is
+// defined. The ref is checked here, blocks are only read from bs when
+// requested. pieceCid is not checked against the car, it must be the piece
+// commitment of the car padded to its piece size, see commp.Calc.
+func (s *Server) Add(ref *carv1.Carv1Ref, bs carv1.BlockGetter, pieceCid cid.Cid) error {
+	if len(ref.DataRef) == 0 || len(ref.DataRef[0].Blocks) == 0 {
+		return xerrors.New("car ref has no root")
+	}
+	root, err := cid.Decode(ref.DataRef[0].Blocks[0])
+	if err != nil {
+		return err
+	}
+	// the bytes of a car depend on its block order, not only on its root
+	data, err := ref.Encode()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	vc, err := carv1.NewVirtualCar(ref, bs, false)
+	if err != nil {
+		return err
+	}
+	var padded *carv1.VirtualCar
+	if pieceCid.Defined() {
+		if padded, err = carv1.NewVirtualCar(ref, bs, true); err != nil {
+			return err
+		}
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.cars[root] = &car{
+		vc:    vc,
+		etag:  fmt.Sprintf(`"%s-%x"`, root, sum[:8]),
+		ctype: "application/vnd.ipld.car",
+	}
+	if padded != nil {
+		s.cars[pieceCid] = &car{
+			vc:    padded,
+			etag:  fmt.Sprintf(`"%s"`, pieceCid),
+			ctype: "application/octet-stream",
+		}
+	}
+	return nil
+}
+
+// Remove stops serving the car or piece c.
+func (s *Server) Remove(c cid.Cid) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	delete(s.cars, c)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSuffix(path.Base(r.URL.Path), ".car")
+	c, err := cid.Decode(name)
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	s.lk.RLock()
+	cr, ok := s.cars[c]
+	s.lk.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", cr.ctype)
+	w.Header().Set("ETag", cr.etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, c.String()+".car", time.Time{}, &logReader{
+		SectionReader: io.NewSectionReader(cr.vc, 0, cr.vc.Size()),
+		c:             c,
+	})
+}
+
+// logReader logs the read errors http.ServeContent drops once the response
+// has started, the client only sees a short body.
+type logReader struct {
+	*io.SectionReader
+	c cid.Cid
+}
+
+func (lr *logReader) Read(p []byte) (int, error) {
+	n, err := lr.SectionReader.Read(p)
+	if err != nil && err != io.EOF {
+		log.Errorf("serve %s: %s", lr.c, err)
+	}
+	return n, err
+}
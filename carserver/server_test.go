@@ -0,0 +1,209 @@
+package carserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/filedrive-team/filehelper/commp"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+)
+
+type testCar struct {
+	ref      *carv1.Carv1Ref
+	bs       blockstore.Blockstore
+	root     cid.Cid
+	pieceCid cid.Cid
+	car      []byte
+	padded   []byte
+}
+
+// newTestCar builds a root over 4 raw leaves of 10KB and the car of its dag.
+func newTestCar(t *testing.T) *testCar {
+	t.Helper()
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	root := merkledag.NodeWithData([]byte("root"))
+	for i := 0; i < 4; i++ {
+		data := make([]byte, 10<<10)
+		rand.Read(data)
+		leaf := merkledag.NewRawNode(data)
+		if err := dag.Add(ctx, leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(fmt.Sprint(i), leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dag.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	b := carv1.NewBatch(ctx, dag)
+	var car bytes.Buffer
+	size, err := b.Write(root.Cid(), &car, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := b.Ref(root.Cid(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded := bytes.NewBuffer(append([]byte{}, car.Bytes()...))
+	if err := carv1.PadCar(padded, int64(size)); err != nil {
+		t.Fatal(err)
+	}
+	cp := commp.NewCalc()
+	if _, err := cp.Write(car.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	pieceCid, _, err := cp.Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCar{
+		ref:      ref,
+		bs:       bs,
+		root:     root.Cid(),
+		pieceCid: pieceCid,
+		car:      car.Bytes(),
+		padded:   padded.Bytes(),
+	}
+}
+
+func request(t *testing.T, srv http.Handler, method, target string, header map[string]string) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func body(t *testing.T, res *http.Response) []byte {
+	t.Helper()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestServer(t *testing.T) {
+	tc := newTestCar(t)
+	srv := New()
+	if err := srv.Add(tc.ref, tc.bs, tc.pieceCid); err != nil {
+		t.Fatal(err)
+	}
+	payload := "/" + tc.root.String()
+	piece := "/" + tc.pieceCid.String() + ".car"
+
+	res := request(t, srv, http.MethodGet, payload, nil)
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body(t, res), tc.car) {
+		t.Fatalf("get car: %s", res.Status)
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" || res.Header.Get("Content-Type") != "application/vnd.ipld.car" || res.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("get car: %v", res.Header)
+	}
+	res = request(t, srv, http.MethodGet, piece, nil)
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body(t, res), tc.padded) {
+		t.Fatalf("get piece: %s", res.Status)
+	}
+	if res.Header.Get("ETag") == etag {
+		t.Fatal("car and piece share an etag")
+	}
+
+	res = request(t, srv, http.MethodHead, payload, nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Length") != fmt.Sprint(len(tc.car)) || len(body(t, res)) != 0 {
+		t.Fatalf("head: %s, %v", res.Status, res.Header)
+	}
+
+	// the padding of the piece is in the range
+	for _, target := range []string{payload, piece} {
+		want := tc.car
+		if target == piece {
+			want = tc.padded
+		}
+		start, end := 100, len(tc.car)+10
+		if target == payload {
+			end = len(tc.car) - 1
+		}
+		res = request(t, srv, http.MethodGet, target, map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", start, end)})
+		if res.StatusCode != http.StatusPartialContent {
+			t.Fatalf("range of %s: %s", target, res.Status)
+		}
+		if cr := res.Header.Get("Content-Range"); cr != fmt.Sprintf("bytes %d-%d/%d", start, end, len(want)) {
+			t.Fatalf("range of %s: content range %s", target, cr)
+		}
+		if !bytes.Equal(body(t, res), want[start:end+1]) {
+			t.Fatalf("range of %s: body differs", target)
+		}
+	}
+
+	res = request(t, srv, http.MethodGet, payload, map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(tc.car))})
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("range past the end: %s", res.Status)
+	}
+	if cr := res.Header.Get("Content-Range"); cr != fmt.Sprintf("bytes */%d", len(tc.car)) {
+		t.Fatalf("range past the end: content range %s", cr)
+	}
+
+	res = request(t, srv, http.MethodGet, payload, map[string]string{"If-None-Match": etag})
+	if res.StatusCode != http.StatusNotModified {
+		t.Fatalf("if-none-match: %s", res.Status)
+	}
+	// a range of a car that changed is served whole
+	res = request(t, srv, http.MethodGet, payload, map[string]string{"Range": "bytes=0-9", "If-Range": `"other"`})
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body(t, res), tc.car) {
+		t.Fatalf("if-range: %s", res.Status)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	tc := newTestCar(t)
+	srv := New()
+	if err := srv.Add(tc.ref, tc.bs, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodPost, "/" + tc.root.String(), http.StatusMethodNotAllowed},
+		{http.MethodGet, "/not-a-cid", http.StatusBadRequest},
+		{http.MethodGet, "/" + tc.pieceCid.String(), http.StatusNotFound},
+	} {
+		if res := request(t, srv, r.method, r.target, nil); res.StatusCode != r.status {
+			t.Fatalf("%s %s: %s", r.method, r.target, res.Status)
+		}
+	}
+	srv.Remove(tc.root)
+	if res := request(t, srv, http.MethodGet, "/"+tc.root.String(), nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("removed car: %s", res.Status)
+	}
+
+	// the ref is checked when the car is added
+	bad := *tc.ref
+	bad.Size++
+	if err := srv.Add(&bad, tc.bs, tc.pieceCid); err == nil {
+		t.Fatal("added a ref whose size is not the size of its blocks")
+	}
+	if res := request(t, srv, http.MethodGet, "/"+tc.pieceCid.String(), nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("piece of a bad ref: %s", res.Status)
+	}
+}
@@ -6,92 +6,138 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strings"
-	"sync"
 
+	"github.com/filedrive-team/filehelper/commp"
 	"github.com/ipfs/go-cid"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	format "github.com/ipfs/go-ipld-format"
 	legacy "github.com/ipfs/go-ipld-legacy"
 	gocar "github.com/ipld/go-car"
 	carutil "github.com/ipld/go-car/util"
-	"golang.org/x/xerrors"
 )
 
 type BatchBuilder struct {
-	ctx context.Context
-	bs  format.NodeGetter
+	ctx   context.Context
+	bs    format.NodeGetter
+	order Order
+	commp bool
 }
 
-func NewBatch(ctx context.Context, bs format.NodeGetter) *BatchBuilder {
-	return &BatchBuilder{
+func NewBatch(ctx context.Context, bs format.NodeGetter, opts ...BatchOption) *BatchBuilder {
+	b := &BatchBuilder{
 		ctx: ctx,
 		bs:  bs,
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 func (b *BatchBuilder) WriteToFile(root cid.Cid, outPath string, batchNum int) error {
+	_, err := b.WriteToFileWithResult(root, outPath, batchNum)
+	return err
+}
+
+// WriteToFileWithResult is WriteToFile returning the WriteResult of WriteWithResult.
+func (b *BatchBuilder) WriteToFileWithResult(root cid.Cid, outPath string, batchNum int) (*WriteResult, error) {
 	f, err := os.Create(outPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
-	_, err = b.Write(root, f, batchNum)
-	return err
+	return b.WriteWithResult(root, f, batchNum)
 }
 
+// Write writes the car v1 file of root to w, with its blocks in the order set by WithOrder.
+// It returns the size of the car file.
 func (b *BatchBuilder) Write(root cid.Cid, w io.Writer, batchNum int) (uint64, error) {
-	nd, err := b.bs.Get(b.ctx, root)
+	res, err := b.WriteWithResult(root, w, batchNum)
 	if err != nil {
 		return 0, err
 	}
+	return res.Size, nil
+}
+
+// WriteWithResult is Write returning the WriteResult of the car file,
+// which holds its piece commitment when the builder has WithCommP.
+func (b *BatchBuilder) WriteWithResult(root cid.Cid, w io.Writer, batchNum int) (*WriteResult, error) {
+	return b.write([]cid.Cid{root}, w, batchNum, nil)
+}
+
+// write writes the car v1 stream of roots, the offset of every section is added to idx if not nil.
+func (b *BatchBuilder) write(roots []cid.Cid, w io.Writer, batchNum int, idx *Index) (*WriteResult, error) {
+	return b.writeWith(roots, w, func(nd format.Node, _ int, seen *cid.Set, cb func(format.Node) error) error {
+		return b.walk(nd, batchNum, seen, cb)
+	}, idx)
+}
+
+// rootWalker calls cb for the blocks of the i-th root nd to write in the car.
+type rootWalker func(nd format.Node, i int, seen *cid.Set, cb func(format.Node) error) error
+
+func (b *BatchBuilder) writeWith(roots []cid.Cid, w io.Writer, walk rootWalker, idx *Index) (*WriteResult, error) {
+	nodes, err := b.getRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+	var cp *commp.Calc
+	if b.commp {
+		cp = commp.NewCalc()
+		w = io.MultiWriter(w, cp)
+	}
 	w = &sw{w: w}
 	var carSize uint64
 	h := &gocar.CarHeader{
-		Roots:   []cid.Cid{root},
+		Roots:   roots,
 		Version: 1,
 	}
 
 	// write header
 	if err := gocar.WriteHeader(h, w); err != nil {
-		return 0, err
+		return nil, err
 	}
 	if hz, err := gocar.HeaderSize(h); err != nil {
-		return 0, err
+		return nil, err
 	} else {
 		carSize += hz
 	}
 
-	// set cid set to only save uniq cid to car file
-	cidSet := cid.NewSet()
-	cidSet.Add(nd.Cid())
-
-	// write data
-	// write root node
-	if err := carutil.LdWrite(w, nd.Cid().Bytes(), nd.RawData()); err != nil {
-		return 0, err
+	// write data, each uniq block once in the order of the builder
+	res := &WriteResult{
+		Roots: make([]RootRange, 0, len(roots)),
 	}
-	carSize += carutil.LdSize(nd.Cid().Bytes(), nd.RawData())
-	//fmt.Printf("cid: %s\n", nd.Cid())
-	if err := BlockWalk(b.ctx, nd, b.bs, batchNum, func(node format.Node) error {
-		if cidSet.Has(node.Cid()) {
+	seen := cid.NewSet()
+	for i, nd := range nodes {
+		rr := RootRange{
+			Root:   nd.Cid(),
+			Offset: carSize,
+		}
+		if err := walk(nd, i, seen, func(node format.Node) error {
+			idx.Add(node.Cid(), carSize)
+			if err := carutil.LdWrite(w, node.Cid().Bytes(), node.RawData()); err != nil {
+				return err
+			}
+			carSize += carutil.LdSize(node.Cid().Bytes(), node.RawData())
+			rr.Blocks++
 			return nil
+		}); err != nil {
+			return nil, err
 		}
-		if err := carutil.LdWrite(w, node.Cid().Bytes(), node.RawData()); err != nil {
-			return err
+		rr.Size = carSize - rr.Offset
+		res.Roots = append(res.Roots, rr)
+	}
+	res.Size = carSize
+	if cp != nil {
+		if res.PieceCID, res.PieceSize, err = cp.Sum(); err != nil {
+			return nil, err
 		}
-		cidSet.Add(node.Cid())
-		carSize += carutil.LdSize(node.Cid().Bytes(), node.RawData())
-		//fmt.Printf("cid: %s\n", node.Cid())
-		return nil
-	}); err != nil {
-		return 0, err
+		res.PayloadSize = cp.PayloadSize()
 	}
 
 	fmt.Printf("car file size: %d, write size: %d\n", carSize, w.(*sw).N())
 
-	return carSize, nil
+	return res, nil
 }
 
 func GetNode(ctx context.Context, cid cid.Cid, bs blockstore.Blockstore) (format.Node, error) {
@@ -102,40 +148,26 @@ func GetNode(ctx context.Context, cid cid.Cid, bs blockstore.Blockstore) (format
 	return legacy.DecodeNode(ctx, nd)
 }
 
+// BlockWalk calls cb for every block below node depth first, each block
+// before the subtrees of its links, in link order. Blocks linked more than
+// once are visited once per link. Links are fetched batchNum at a time.
+//
+// Deprecated: Write visits each block once in the order set by WithOrder,
+// OrderDFS being the order of BlockWalk.
 func BlockWalk(ctx context.Context, node format.Node, bs format.NodeGetter, batchNum int, cb func(nd format.Node) error) error {
 	links := node.Links()
 	if len(links) == 0 {
 		return nil
 	}
-	loadedNode := make([]format.Node, len(links))
-	errmsg := make([]string, 0)
-	var wg sync.WaitGroup
-	batchchan := make(chan struct{}, batchNum)
-	wg.Add(len(links))
-	for i, link := range links {
-		go func(ctx context.Context, i int, link *format.Link, bs format.NodeGetter) {
-			defer func() {
-				<-batchchan
-				wg.Done()
-			}()
-			batchchan <- struct{}{}
-			var nd format.Node
-			var err error
-			nd, err = bs.Get(ctx, link.Cid)
-			if err != nil {
-				// try get one more time
-				if nd, err = bs.Get(ctx, link.Cid); err != nil {
-					errmsg = append(errmsg, err.Error())
-				}
-			}
-			loadedNode[i] = nd
-		}(ctx, i, link, bs)
+	cids := make([]cid.Cid, 0, len(links))
+	for _, l := range links {
+		cids = append(cids, l.Cid)
 	}
-	wg.Wait()
-	if len(errmsg) > 0 {
-		return xerrors.New(strings.Join(errmsg, "\n"))
+	nodes, err := getNodes(ctx, bs, cids, batchNum)
+	if err != nil {
+		return err
 	}
-	for _, nd := range loadedNode {
+	for _, nd := range nodes {
 		if err := cb(nd); err != nil {
 			return err
 		}
@@ -188,7 +220,19 @@ func (ng *offlineng) Get(ctx context.Context, cid cid.Cid) (format.Node, error)
 }
 
 func (ng *offlineng) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
-	return nil
+	out := make(chan *format.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for _, c := range cids {
+			nd, err := ng.Get(ctx, c)
+			select {
+			case out <- &format.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
 }
 
 func NewOfflineNodeGetter(ng blockstore.Blockstore) *offlineng {
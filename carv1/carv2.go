@@ -0,0 +1,121 @@
+package carv1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// CarV2Pragma is the fixed prefix of car v2 files, a car v1 style header
+// holding only {version: 2}.
+var CarV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// CarV2HeaderSize is the size of the header following the pragma.
+const CarV2HeaderSize = 40
+
+// CarV2Header locates the car v1 payload and the index inside a car v2 file.
+type CarV2Header struct {
+	Characteristics [2]uint64 `json:"characteristics"`
+	DataOffset      uint64    `json:"data_offset"`
+	DataSize        uint64    `json:"data_size"`
+	// IndexOffset is 0 when the file has no index
+	IndexOffset uint64 `json:"index_offset"`
+}
+
+func (h *CarV2Header) bytes() []byte {
+	buf := make([]byte, CarV2HeaderSize)
+	binary.LittleEndian.PutUint64(buf[0:], h.Characteristics[0])
+	binary.LittleEndian.PutUint64(buf[8:], h.Characteristics[1])
+	binary.LittleEndian.PutUint64(buf[16:], h.DataOffset)
+	binary.LittleEndian.PutUint64(buf[24:], h.DataSize)
+	binary.LittleEndian.PutUint64(buf[32:], h.IndexOffset)
+	return buf
+}
+
+// ReadCarV2Header reads the pragma and header at the start of a car v2 file.
+func ReadCarV2Header(r io.Reader) (*CarV2Header, error) {
+	buf := make([]byte, len(CarV2Pragma)+CarV2HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:len(CarV2Pragma)], CarV2Pragma) {
+		return nil, xerrors.New("not a car v2 file")
+	}
+	buf = buf[len(CarV2Pragma):]
+	return &CarV2Header{
+		Characteristics: [2]uint64{binary.LittleEndian.Uint64(buf[0:]), binary.LittleEndian.Uint64(buf[8:])},
+		DataOffset:      binary.LittleEndian.Uint64(buf[16:]),
+		DataSize:        binary.LittleEndian.Uint64(buf[24:]),
+		IndexOffset:     binary.LittleEndian.Uint64(buf[32:]),
+	}, nil
+}
+
+func (b *BatchBuilder) WriteV2ToFile(root cid.Cid, outPath string, batchNum int) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = b.WriteV2(root, f, batchNum)
+	return err
+}
+
+// WriteV2 writes root as a car v2 file: the pragma and header, the same car v1
+// payload Write gives, then a MultihashIndexSorted index of the payload.
+// The header is written last, w must be positioned at the start of the file.
+// It returns the size of the whole file.
+func (b *BatchBuilder) WriteV2(root cid.Cid, w io.WriteSeeker, batchNum int) (uint64, error) {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	h := &CarV2Header{
+		DataOffset: uint64(len(CarV2Pragma) + CarV2HeaderSize),
+	}
+	// reserve the header, filled in once the sizes are known
+	if _, err := w.Write(make([]byte, h.DataOffset)); err != nil {
+		return 0, err
+	}
+	idx := NewIndex()
+	res, err := b.write([]cid.Cid{root}, w, batchNum, idx)
+	if err != nil {
+		return 0, err
+	}
+	h.DataSize = res.Size
+	h.IndexOffset = h.DataOffset + res.Size
+	idxSize, err := idx.WriteTo(w)
+	if err != nil {
+		return 0, xerrors.Errorf("write index: %w", err)
+	}
+	if _, err := w.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(append(append([]byte{}, CarV2Pragma...), h.bytes()...)); err != nil {
+		return 0, err
+	}
+	carSize := h.IndexOffset + uint64(idxSize)
+	if _, err := w.Seek(start+int64(carSize), io.SeekStart); err != nil {
+		return 0, err
+	}
+	return carSize, nil
+}
+
+// WriteWithIndex writes the car v1 file of root to outPath and its detached index to idxPath.
+func (b *BatchBuilder) WriteWithIndex(root cid.Cid, outPath, idxPath string, batchNum int) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	idx := NewIndex()
+	if _, err := b.write([]cid.Cid{root}, f, batchNum, idx); err != nil {
+		return err
+	}
+	return writeIndexFile(idx, idxPath)
+}
@@ -0,0 +1,368 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+)
+
+func newDAG() format.DAGService {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	return merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+// buildFile imports data into dag as a unixfs file of 1MiB chunks,
+// zero chunks all share the same leaf.
+func buildFile(t *testing.T, dag format.DAGService, data []byte) format.Node {
+	t.Helper()
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := filehelper.BalanceNode(bytes.NewReader(data), dag, cidBuilder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+// randZeroData is n random MiB followed by z zero MiB.
+func randZeroData(n, z int) []byte {
+	data := make([]byte, (n+z)<<20)
+	rand.Read(data[:n<<20])
+	return data
+}
+
+// carSections returns the data of every section of a car v1 payload by offset.
+func carSections(t *testing.T, payload []byte) map[uint64]*Section {
+	t.Helper()
+	cr, err := NewCarReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[uint64]*Section)
+	for {
+		s, err := cr.Next()
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[s.Offset] = s
+	}
+}
+
+// TestWriteV2 checks the car v2 layout field by field against the spec:
+// pragma, header, the car v1 payload of Write, then a MultihashIndexSorted
+// index pointing at every section of the payload.
+func TestWriteV2(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	nd := buildFile(t, dag, randZeroData(3, 2))
+	b := NewBatch(ctx, dag)
+
+	var v1 bytes.Buffer
+	if _, err := b.Write(nd.Cid(), &v1, 2); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "v2.car")
+	if err := b.WriteV2ToFile(nd.Cid(), path, 2); err != nil {
+		t.Fatal(err)
+	}
+	v2, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(v2[:11], []byte{0x0a, 0xa1, 0x67, 'v', 'e', 'r', 's', 'i', 'o', 'n', 0x02}) {
+		t.Fatalf("bad pragma: %x", v2[:11])
+	}
+	hdr := v2[11:51]
+	if !bytes.Equal(hdr[:16], make([]byte, 16)) {
+		t.Fatalf("characteristics set: %x", hdr[:16])
+	}
+	dataOffset := binary.LittleEndian.Uint64(hdr[16:])
+	dataSize := binary.LittleEndian.Uint64(hdr[24:])
+	indexOffset := binary.LittleEndian.Uint64(hdr[32:])
+	if dataOffset != 51 || dataSize != uint64(v1.Len()) || indexOffset != dataOffset+dataSize {
+		t.Fatalf("header: data %d+%d, index at %d, payload of %d bytes", dataOffset, dataSize, indexOffset, v1.Len())
+	}
+	if !bytes.Equal(v2[dataOffset:indexOffset], v1.Bytes()) {
+		t.Fatal("payload differs from Write")
+	}
+
+	sections := carSections(t, v1.Bytes())
+	r := bytes.NewReader(v2[indexOffset:])
+	codec, err := binary.ReadUvarint(r)
+	if err != nil || codec != 0x0401 {
+		t.Fatalf("index codec 0x%x: %v", codec, err)
+	}
+	var codes int32
+	binary.Read(r, binary.LittleEndian, &codes)
+	if codes != 1 {
+		t.Fatalf("%d multihash codes, expected sha2-256 only", codes)
+	}
+	var code uint64
+	var buckets, width int32
+	var size int64
+	binary.Read(r, binary.LittleEndian, &code)
+	binary.Read(r, binary.LittleEndian, &buckets)
+	binary.Read(r, binary.LittleEndian, &width)
+	binary.Read(r, binary.LittleEndian, &size)
+	if code != multihash.SHA2_256 || buckets != 1 || width != 40 || size != int64(len(sections))*40 {
+		t.Fatalf("bucket: code 0x%x, %d buckets, width %d, size %d for %d sections", code, buckets, width, size, len(sections))
+	}
+	var prev []byte
+	for i := 0; i < len(sections); i++ {
+		rec := make([]byte, width)
+		if _, err := io.ReadFull(r, rec); err != nil {
+			t.Fatal(err)
+		}
+		digest, offset := rec[:32], binary.LittleEndian.Uint64(rec[32:])
+		if bytes.Compare(prev, digest) >= 0 {
+			t.Fatalf("record %d out of order", i)
+		}
+		prev = digest
+		s, ok := sections[offset]
+		if !ok {
+			t.Fatalf("record %d points at %d, not a section", i, offset)
+		}
+		dmh, err := multihash.Decode(s.Cid.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dmh.Digest, digest) {
+			t.Fatalf("record %d points at %s", i, s.Cid)
+		}
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d bytes after the index", r.Len())
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	dag := newDAG()
+	nd := buildFile(t, dag, randZeroData(2, 2))
+	var car bytes.Buffer
+	if _, err := NewBatch(context.Background(), dag).Write(nd.Cid(), &car, 1); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := GenerateIndex(bytes.NewReader(car.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha2-256 root, 2 random leaves and the shared zero leaf
+	if idx.Len() != 4 {
+		t.Fatalf("%d blocks indexed", idx.Len())
+	}
+	// identity cids are not indexed
+	id, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}.Sum([]byte("id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(id, 1)
+	if _, ok := idx.Lookup(id); ok {
+		t.Fatal("identity cid indexed")
+	}
+	// a second hash function gets its own bucket
+	sha512, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_512}.Sum([]byte("512"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(sha512, 12345)
+
+	var buf bytes.Buffer
+	n, err := idx.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d for %d bytes", n, buf.Len())
+	}
+	got, err := ReadIndex(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != idx.Len() {
+		t.Fatalf("read %d records, wrote %d", got.Len(), idx.Len())
+	}
+	for mh, offset := range idx.offsets {
+		if got.offsets[mh] != offset {
+			t.Fatalf("offset %d read back as %d", offset, got.offsets[mh])
+		}
+	}
+	if _, err := ReadIndex(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Fatal("truncated index read")
+	}
+}
+
+func TestCarNodeGetter(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	nd := buildFile(t, dag, randZeroData(3, 2))
+	b := NewBatch(ctx, dag)
+	dir := t.TempDir()
+
+	v1 := filepath.Join(dir, "v1.car")
+	if err := b.WriteToFile(nd.Cid(), v1, 1); err != nil {
+		t.Fatal(err)
+	}
+	v1idx := filepath.Join(dir, "v1idx.car")
+	idxPath := filepath.Join(dir, "v1.idx")
+	if err := b.WriteWithIndex(nd.Cid(), v1idx, idxPath, 1); err != nil {
+		t.Fatal(err)
+	}
+	v2 := filepath.Join(dir, "v2.car")
+	if err := b.WriteV2ToFile(nd.Cid(), v2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	open := map[string]func() (*CarNodeGetter, error){
+		"v1":         func() (*CarNodeGetter, error) { return OpenCarNodeGetter(v1) },
+		"v1 indexed": func() (*CarNodeGetter, error) { return OpenCarNodeGetterWithIndex(v1idx, idxPath) },
+		"v2":         func() (*CarNodeGetter, error) { return OpenCarNodeGetter(v2) },
+	}
+	missing, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum([]byte("missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, open := range open {
+		t.Run(name, func(t *testing.T) {
+			ng, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ng.Close()
+			if roots := ng.Roots(); len(roots) != 1 || !roots[0].Equals(nd.Cid()) {
+				t.Fatalf("roots %v", roots)
+			}
+			for _, c := range append([]cid.Cid{nd.Cid()}, linkCids(nd)...) {
+				want, err := dag.Get(ctx, c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ng.Has(c) {
+					t.Fatalf("%s missing", c)
+				}
+				got, err := ng.Get(ctx, c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.RawData(), want.RawData()) {
+					t.Fatalf("%s differs", c)
+				}
+			}
+			if ng.Has(missing) {
+				t.Fatal("has a missing block")
+			}
+			if _, err := ng.Get(ctx, missing); !xerrors.Is(err, format.ErrNotFound) {
+				t.Fatalf("get a missing block: %v", err)
+			}
+		})
+	}
+}
+
+// TestCarNodeGetterBadIndex checks that an index pointing at the wrong
+// section is reported rather than returning the data of another block.
+func TestCarNodeGetterBadIndex(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	nd := buildFile(t, dag, randZeroData(2, 0))
+	dir := t.TempDir()
+	carPath := filepath.Join(dir, "v1.car")
+	idxPath := filepath.Join(dir, "v1.idx")
+	if err := NewBatch(ctx, dag).WriteWithIndex(nd.Cid(), carPath, idxPath, 1); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadIndexFile(idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := linkCids(nd)
+	first, _ := idx.Lookup(leaves[0])
+	idx.offsets[string(leaves[1].Hash())] = first
+	if err := writeIndexFile(idx, idxPath); err != nil {
+		t.Fatal(err)
+	}
+	ng, err := OpenCarNodeGetterWithIndex(carPath, idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ng.Close()
+	if _, err := ng.Get(ctx, leaves[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ng.Get(ctx, leaves[1]); err == nil {
+		t.Fatal("got the block of another cid")
+	}
+}
+
+func linkCids(nd format.Node) []cid.Cid {
+	res := make([]cid.Cid, 0, len(nd.Links()))
+	for _, l := range nd.Links() {
+		res = append(res, l.Cid)
+	}
+	return res
+}
+
+// TestCarNodeGetterLongCid reads a block through an index when its cid is
+// longer than any fixed read-ahead of the section header, the digest is
+// oversized for its hash function but block hashes are not checked on read.
+func TestCarNodeGetterLongCid(t *testing.T) {
+	digest := make([]byte, 300)
+	rand.Read(digest)
+	mh, err := multihash.Encode(digest, multihash.BLAKE2B_MAX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cid.NewCidV1(cid.Raw, mh)
+	data := []byte("block behind a long cid")
+	var car bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: []cid.Cid{c}, Version: 1}, &car); err != nil {
+		t.Fatal(err)
+	}
+	if err := carutil.LdWrite(&car, c.Bytes(), data); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	carPath := filepath.Join(dir, "long.car")
+	idxPath := filepath.Join(dir, "long.idx")
+	if err := os.WriteFile(carPath, car.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateIndexFile(carPath, idxPath); err != nil {
+		t.Fatal(err)
+	}
+	ng, err := OpenCarNodeGetterWithIndex(carPath, idxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ng.Close()
+	blk, err := ng.GetBlock(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blk.RawData(), data) {
+		t.Fatalf("read %q", blk.RawData())
+	}
+}
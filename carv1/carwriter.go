@@ -0,0 +1,246 @@
+package carv1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	legacy "github.com/ipfs/go-ipld-legacy"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"golang.org/x/xerrors"
+)
+
+var errAppendOnly = xerrors.New("car writer is append only")
+
+// CarWriter is a DAGService writing the blocks added to it straight into a
+// car v1 file, in the order they are added, so an import can go from disk
+// to car without an intermediate blockstore. Room for a header with rootNum
+// roots is reserved at the start of the file and the header is written by
+// Finish once the roots are known. Blocks written so far can be read back,
+// blocks are only written once.
+//
+// The blocks are in the order they are added, an import adds the leaves of a
+// file before their parents and the root last. The car file, and so its piece
+// cid, then differs from the one BatchBuilder.Write makes for the same roots,
+// where every block comes before the blocks it links to.
+type CarWriter struct {
+	lk      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	rootNum int
+	// cidLen is the length of the cids the header was sized for
+	cidLen int
+	hsize  uint64
+	start  uint64
+	offset uint64
+	pos    map[cid.Cid]blockPos
+}
+
+// CreateCarWriter creates the car file at path for rootNum roots made by cidBuilder.
+func CreateCarWriter(path string, rootNum int, cidBuilder cid.Builder) (*CarWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	cw, err := NewCarWriter(f, rootNum, cidBuilder)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cw, nil
+}
+
+// NewCarWriter writes a car file into f from its current position, every
+// root must have a cid of the same length as the ones cidBuilder makes.
+func NewCarWriter(f *os.File, rootNum int, cidBuilder cid.Builder) (*CarWriter, error) {
+	if rootNum < 1 {
+		return nil, xerrors.Errorf("invalid number of roots: %d", rootNum)
+	}
+	placeholder, err := cidBuilder.Sum(nil)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]cid.Cid, rootNum)
+	for i := range roots {
+		roots[i] = placeholder
+	}
+	hsize, err := gocar.HeaderSize(&gocar.CarHeader{
+		Roots:   roots,
+		Version: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(start+int64(hsize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &CarWriter{
+		f:       f,
+		w:       bufio.NewWriterSize(f, 1<<20),
+		rootNum: rootNum,
+		cidLen:  len(placeholder.Bytes()),
+		hsize:   hsize,
+		start:   uint64(start),
+		offset:  uint64(start) + hsize,
+		pos:     make(map[cid.Cid]blockPos),
+	}, nil
+}
+
+func (cw *CarWriter) Add(ctx context.Context, nd format.Node) error {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+	return cw.add(nd)
+}
+
+func (cw *CarWriter) AddMany(ctx context.Context, nds []format.Node) error {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+	for _, nd := range nds {
+		if err := cw.add(nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cw *CarWriter) add(nd format.Node) error {
+	if cw.w == nil {
+		return xerrors.New("car writer is finished")
+	}
+	if _, ok := cw.pos[nd.Cid()]; ok {
+		return nil
+	}
+	cb := nd.Cid().Bytes()
+	if err := carutil.LdWrite(cw.w, cb, nd.RawData()); err != nil {
+		return err
+	}
+	size := carutil.LdSize(cb, nd.RawData())
+	cw.pos[nd.Cid()] = blockPos{
+		offset: cw.offset + size - uint64(len(nd.RawData())),
+		size:   uint64(len(nd.RawData())),
+	}
+	cw.offset += size
+	return nil
+}
+
+func (cw *CarWriter) Has(c cid.Cid) bool {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+	_, ok := cw.pos[c]
+	return ok
+}
+
+func (cw *CarWriter) GetBlock(c cid.Cid) (blocks.Block, error) {
+	cw.lk.Lock()
+	p, ok := cw.pos[c]
+	var err error
+	if ok && cw.w != nil {
+		// the block may still be buffered
+		err = cw.w.Flush()
+	}
+	cw.lk.Unlock()
+	if !ok {
+		return nil, format.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, p.size)
+	if _, err := cw.f.ReadAt(data, int64(p.offset)); err != nil {
+		return nil, xerrors.Errorf("read block %s: %w", c, err)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (cw *CarWriter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	blk, err := cw.GetBlock(c)
+	if err != nil {
+		return nil, err
+	}
+	return legacy.DecodeNode(ctx, blk)
+}
+
+func (cw *CarWriter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for _, c := range cids {
+			nd, err := cw.Get(ctx, c)
+			select {
+			case out <- &format.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (cw *CarWriter) Remove(context.Context, cid.Cid) error {
+	return errAppendOnly
+}
+
+func (cw *CarWriter) RemoveMany(context.Context, []cid.Cid) error {
+	return errAppendOnly
+}
+
+// Finish flushes the blocks and writes the header with roots into the reserved
+// room, roots must all have been added. It returns the size of the car file.
+func (cw *CarWriter) Finish(roots []cid.Cid) (uint64, error) {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+	if cw.w == nil {
+		return 0, xerrors.New("car writer is finished")
+	}
+	if len(roots) != cw.rootNum {
+		return 0, xerrors.Errorf("expected %d roots, got %d", cw.rootNum, len(roots))
+	}
+	for _, root := range roots {
+		if l := len(root.Bytes()); l != cw.cidLen {
+			return 0, xerrors.Errorf("root %s is a %d byte cid, the header was reserved for the %d byte cids of the writer's cid builder", root, l, cw.cidLen)
+		}
+		if _, ok := cw.pos[root]; !ok {
+			return 0, xerrors.Errorf("root %s was not written", root)
+		}
+	}
+	var header bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{
+		Roots:   roots,
+		Version: 1,
+	}, &header); err != nil {
+		return 0, err
+	}
+	if uint64(header.Len()) != cw.hsize {
+		return 0, xerrors.Errorf("header of %d bytes does not fit the %d bytes reserved", header.Len(), cw.hsize)
+	}
+	if err := cw.w.Flush(); err != nil {
+		return 0, err
+	}
+	if _, err := cw.f.WriteAt(header.Bytes(), int64(cw.start)); err != nil {
+		return 0, err
+	}
+	cw.w = nil
+	return cw.offset - cw.start, nil
+}
+
+// Close closes the file, the car file is incomplete unless Finish succeeded.
+func (cw *CarWriter) Close() error {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+	if cw.w != nil {
+		cw.w.Flush()
+		cw.w = nil
+	}
+	return cw.f.Close()
+}
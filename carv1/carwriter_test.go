@@ -0,0 +1,110 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestCarWriter(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	path := filepath.Join(t.TempDir(), "stream.car")
+	cw, err := CreateCarWriter(path, 1, merkledag.V1CidPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cw.Close()
+	// leaves first then parents, the way an import adds them
+	for _, name := range []string{"f", "g", "c", "d", "a", "e", "b", "c", "root"} {
+		if err := cw.Add(ctx, tt.nodes[name]); err != nil {
+			t.Fatal(err)
+		}
+		// blocks are readable before Finish
+		got, err := cw.Get(ctx, tt.nodes[name].Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.RawData(), tt.nodes[name].RawData()) {
+			t.Fatalf("%s read back differs", name)
+		}
+	}
+	if err := cw.Remove(ctx, tt.root.Cid()); err == nil {
+		t.Fatal("removed a block")
+	}
+	if _, err := cw.Finish([]cid.Cid{tt.nodes["d"].Cid(), tt.root.Cid()}); err == nil {
+		t.Fatal("finished with more roots than reserved")
+	}
+	size, err := cw.Finish([]cid.Cid{tt.root.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Add(ctx, tt.root); err == nil {
+		t.Fatal("added a block after Finish")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	report, err := VerifyCar(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Blocks != len(tt.nodes) || report.Size != size {
+		t.Fatalf("%+v", report)
+	}
+	// same blocks as Write, in another order
+	var car bytes.Buffer
+	if _, err := NewBatch(ctx, dag).Write(tt.root.Cid(), &car, 1); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(car.Len()) != size {
+		t.Fatalf("%d bytes, Write gives %d", size, car.Len())
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(written, car.Bytes()) {
+		t.Fatal("leaves first car has the layout of Write")
+	}
+}
+
+func TestCarWriterRootLength(t *testing.T) {
+	ctx := context.Background()
+	v0, err := merkledag.PrefixForCidVersion(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha512 := merkledag.V1CidPrefix()
+	sha512.MhType, sha512.MhLength = multihash.SHA2_512, -1
+	for name, builder := range map[string]cid.Builder{"shorter": v0, "longer": sha512} {
+		cw, err := CreateCarWriter(filepath.Join(t.TempDir(), "root.car"), 1, merkledag.V1CidPrefix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		nd := merkledag.NodeWithData([]byte("root"))
+		nd.SetCidBuilder(builder)
+		if err := cw.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		_, err = cw.Finish([]cid.Cid{nd.Cid()})
+		if err == nil || !strings.Contains(err.Error(), "header was reserved") {
+			t.Fatalf("%s root cid: %v", name, err)
+		}
+		cw.Close()
+	}
+}
@@ -0,0 +1,198 @@
+package carv1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	legacy "github.com/ipfs/go-ipld-legacy"
+	"golang.org/x/xerrors"
+)
+
+type blockPos struct {
+	offset uint64
+	size   uint64
+}
+
+// CarNodeGetter serves the blocks of a car file without loading them into
+// a blockstore. Car v1 files (padded or not) and car v2 files without an index
+// are scanned and their block positions kept in memory, car v2 files with an
+// index and car v1 files with a detached index are read through the index.
+type CarNodeGetter struct {
+	f     *os.File
+	roots []cid.Cid
+	pos   map[cid.Cid]blockPos
+	// idx is the index of the payload starting at base
+	idx  *Index
+	base uint64
+}
+
+// OpenCarNodeGetter opens the car file at path and indexes its blocks.
+func OpenCarNodeGetter(path string) (*CarNodeGetter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ng, err := newCarNodeGetter(f, nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ng, nil
+}
+
+// OpenCarNodeGetterWithIndex opens the car v1 file at carPath using the detached index at idxPath.
+func OpenCarNodeGetterWithIndex(carPath, idxPath string) (*CarNodeGetter, error) {
+	idx, err := ReadIndexFile(idxPath)
+	if err != nil {
+		return nil, xerrors.Errorf("read index %s: %w", idxPath, err)
+	}
+	f, err := os.Open(carPath)
+	if err != nil {
+		return nil, err
+	}
+	ng, err := newCarNodeGetter(f, idx)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ng, nil
+}
+
+func newCarNodeGetter(f *os.File, idx *Index) (*CarNodeGetter, error) {
+	ng := &CarNodeGetter{
+		f:   f,
+		pos: make(map[cid.Cid]blockPos),
+		idx: idx,
+	}
+	finfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var data io.Reader = io.NewSectionReader(f, 0, finfo.Size())
+	if h, err := ReadCarV2Header(io.NewSectionReader(f, 0, finfo.Size())); err == nil {
+		if idx != nil {
+			return nil, xerrors.New("detached index given for a car v2 file")
+		}
+		ng.base = h.DataOffset
+		data = io.NewSectionReader(f, int64(h.DataOffset), int64(h.DataSize))
+		if h.IndexOffset != 0 {
+			if ng.idx, err = ReadIndex(io.NewSectionReader(f, int64(h.IndexOffset), finfo.Size()-int64(h.IndexOffset))); err != nil {
+				return nil, xerrors.Errorf("read car v2 index: %w", err)
+			}
+		}
+	}
+	cr, err := NewCarReader(data)
+	if err != nil {
+		return nil, err
+	}
+	ng.roots = cr.Header.Roots
+	if ng.idx != nil {
+		return ng, nil
+	}
+	for {
+		s, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		ng.pos[s.Cid] = blockPos{offset: ng.base + s.DataOffset, size: uint64(len(s.Data))}
+	}
+	return ng, nil
+}
+
+func (ng *CarNodeGetter) Roots() []cid.Cid {
+	return ng.roots
+}
+
+func (ng *CarNodeGetter) Has(c cid.Cid) bool {
+	if ng.idx != nil {
+		_, ok := ng.idx.Lookup(c)
+		return ok
+	}
+	_, ok := ng.pos[c]
+	return ok
+}
+
+func (ng *CarNodeGetter) GetBlock(c cid.Cid) (blocks.Block, error) {
+	p, err := ng.blockPos(c)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, p.size)
+	if _, err := ng.f.ReadAt(data, int64(p.offset)); err != nil {
+		return nil, xerrors.Errorf("read block %s: %w", c, err)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (ng *CarNodeGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	blk, err := ng.GetBlock(c)
+	if err != nil {
+		return nil, err
+	}
+	return legacy.DecodeNode(ctx, blk)
+}
+
+func (ng *CarNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for _, c := range cids {
+			nd, err := ng.Get(ctx, c)
+			select {
+			case out <- &format.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (ng *CarNodeGetter) Close() error {
+	return ng.f.Close()
+}
+
+// blockPos finds the data of c, through the index when there is one.
+func (ng *CarNodeGetter) blockPos(c cid.Cid) (blockPos, error) {
+	if ng.idx == nil {
+		p, ok := ng.pos[c]
+		if !ok {
+			return blockPos{}, format.ErrNotFound
+		}
+		return p, nil
+	}
+	offset, ok := ng.idx.Lookup(c)
+	if !ok {
+		return blockPos{}, format.ErrNotFound
+	}
+	offset += ng.base
+	// a section starts with its length varint, then the cid whose
+	// varints declare its own length
+	buf := make([]byte, binary.MaxVarintLen64)
+	n, err := ng.f.ReadAt(buf, int64(offset))
+	if err != nil && err != io.EOF {
+		return blockPos{}, err
+	}
+	l, vz := binary.Uvarint(buf[:n])
+	if vz <= 0 {
+		return blockPos{}, xerrors.Errorf("invalid section at %d", offset)
+	}
+	cz, sc, err := cid.CidFromReader(bufio.NewReader(io.NewSectionReader(ng.f, int64(offset)+int64(vz), int64(l))))
+	if err != nil {
+		return blockPos{}, xerrors.Errorf("read cid at %d: %w", offset, err)
+	}
+	if !bytes.Equal(sc.Hash(), c.Hash()) {
+		return blockPos{}, xerrors.Errorf("index points at %s instead of %s", sc, c)
+	}
+	return blockPos{offset: offset + uint64(vz+cz), size: l - uint64(cz)}, nil
+}
@@ -0,0 +1,259 @@
+package carv1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+)
+
+// multicodec codes of the car v2 index formats
+const (
+	IndexSortedCodec          = 0x0400
+	MultihashIndexSortedCodec = 0x0401
+)
+
+// Index maps the multihash of each block of a car v1 payload to the offset
+// of its section, relative to the start of the payload. It is serialized in
+// the car v2 MultihashIndexSorted format, which is also the format of
+// detached .idx files.
+type Index struct {
+	offsets map[string]uint64
+}
+
+func NewIndex() *Index {
+	return &Index{
+		offsets: make(map[string]uint64),
+	}
+}
+
+// Add records the section offset of c, identity cids are not indexed.
+func (idx *Index) Add(c cid.Cid, offset uint64) {
+	if idx == nil || c.Prefix().MhType == multihash.IDENTITY {
+		return
+	}
+	if _, ok := idx.offsets[string(c.Hash())]; ok {
+		return
+	}
+	idx.offsets[string(c.Hash())] = offset
+}
+
+// Lookup returns the section offset of the block with the multihash of c.
+func (idx *Index) Lookup(c cid.Cid) (uint64, bool) {
+	offset, ok := idx.offsets[string(c.Hash())]
+	return offset, ok
+}
+
+func (idx *Index) Len() int {
+	return len(idx.offsets)
+}
+
+type indexRecord struct {
+	digest []byte
+	offset uint64
+}
+
+// WriteTo writes the index codec followed by the index buckets: records are
+// grouped by multihash code then digest length, both in ascending order,
+// and sorted by digest within each bucket.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	buckets := make(map[uint64]map[int][]indexRecord)
+	for mh, offset := range idx.offsets {
+		dmh, err := multihash.Decode([]byte(mh))
+		if err != nil {
+			return 0, err
+		}
+		if buckets[dmh.Code] == nil {
+			buckets[dmh.Code] = make(map[int][]indexRecord)
+		}
+		buckets[dmh.Code][len(dmh.Digest)] = append(buckets[dmh.Code][len(dmh.Digest)], indexRecord{
+			digest: dmh.Digest,
+			offset: offset,
+		})
+	}
+	codes := make([]uint64, 0, len(buckets))
+	for code := range buckets {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	writeUvarint(cw, MultihashIndexSortedCodec)
+	binary.Write(cw, binary.LittleEndian, int32(len(codes)))
+	for _, code := range codes {
+		binary.Write(cw, binary.LittleEndian, code)
+		widths := make([]int, 0, len(buckets[code]))
+		for width := range buckets[code] {
+			widths = append(widths, width)
+		}
+		sort.Ints(widths)
+		binary.Write(cw, binary.LittleEndian, int32(len(widths)))
+		for _, width := range widths {
+			records := buckets[code][width]
+			sort.Slice(records, func(i, j int) bool {
+				return bytes.Compare(records[i].digest, records[j].digest) < 0
+			})
+			binary.Write(cw, binary.LittleEndian, uint32(width+8))
+			binary.Write(cw, binary.LittleEndian, int64(len(records)*(width+8)))
+			for _, r := range records {
+				cw.Write(r.digest)
+				binary.Write(cw, binary.LittleEndian, r.offset)
+			}
+		}
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// ReadIndex reads an index in the MultihashIndexSorted format. The older
+// IndexSorted format is also accepted, its records are then matched on the
+// digest only since it does not keep the multihash codes.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	codec, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, xerrors.Errorf("read index codec: %w", err)
+	}
+	idx := NewIndex()
+	switch codec {
+	case MultihashIndexSortedCodec:
+		var n int32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		for i := int32(0); i < n; i++ {
+			var code uint64
+			if err := binary.Read(br, binary.LittleEndian, &code); err != nil {
+				return nil, err
+			}
+			if err := idx.readBuckets(br, code); err != nil {
+				return nil, err
+			}
+		}
+	case IndexSortedCodec:
+		if err := idx.readBuckets(br, multihash.SHA2_256); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, xerrors.Errorf("unsupported index codec: 0x%x", codec)
+	}
+	return idx, nil
+}
+
+func (idx *Index) readBuckets(br *bufio.Reader, code uint64) error {
+	var n int32
+	if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+		return err
+	}
+	for i := int32(0); i < n; i++ {
+		var width uint32
+		var size int64
+		if err := binary.Read(br, binary.LittleEndian, &width); err != nil {
+			return err
+		}
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return err
+		}
+		if width <= 8 || size < 0 || size%int64(width) != 0 {
+			return xerrors.Errorf("invalid index bucket: width %d, size %d", width, size)
+		}
+		rec := make([]byte, width)
+		for j := int64(0); j < size/int64(width); j++ {
+			if _, err := io.ReadFull(br, rec); err != nil {
+				return err
+			}
+			digest := rec[:width-8]
+			mh, err := multihash.Encode(digest, code)
+			if err != nil {
+				return err
+			}
+			idx.offsets[string(mh)] = binary.LittleEndian.Uint64(rec[width-8:])
+		}
+	}
+	return nil
+}
+
+// GenerateIndex indexes the sections of the car v1 stream r.
+func GenerateIndex(r io.Reader) (*Index, error) {
+	cr, err := NewCarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	idx := NewIndex()
+	for {
+		s, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return idx, nil
+			}
+			return nil, err
+		}
+		idx.Add(s.Cid, s.Offset)
+	}
+}
+
+// GenerateIndexFile writes the detached index of the car v1 file at carPath to idxPath.
+func GenerateIndexFile(carPath, idxPath string) error {
+	f, err := os.Open(carPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	idx, err := GenerateIndex(f)
+	if err != nil {
+		return err
+	}
+	return writeIndexFile(idx, idxPath)
+}
+
+// ReadIndexFile reads a detached index file.
+func ReadIndexFile(idxPath string) (*Index, error) {
+	f, err := os.Open(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadIndex(f)
+}
+
+func writeIndexFile(idx *Index, idxPath string) error {
+	f, err := os.Create(idxPath)
+	if err != nil {
+		return err
+	}
+	if _, err := idx.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+func writeUvarint(w io.Writer, v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.Write(buf[:binary.PutUvarint(buf, v)])
+}
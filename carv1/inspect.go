@@ -0,0 +1,251 @@
+package carv1
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"golang.org/x/xerrors"
+)
+
+// BlockInfo locates a block of a car file.
+type BlockInfo struct {
+	Cid    cid.Cid `json:"cid"`
+	Offset uint64  `json:"offset"`
+	// Size of the block data
+	Size uint64 `json:"size"`
+}
+
+type CodecStats struct {
+	Codec  string `json:"codec"`
+	Blocks int    `json:"blocks"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// DagStats describes the dag of one root, as far as its blocks are in the car.
+type DagStats struct {
+	Root cid.Cid `json:"root"`
+	// Depth is the number of levels of the dag, 1 for a single block
+	Depth  int    `json:"depth"`
+	Blocks int    `json:"blocks"`
+	Bytes  uint64 `json:"bytes"`
+	// fanout of the nodes having links
+	MaxFanout int     `json:"max_fanout"`
+	AvgFanout float64 `json:"avg_fanout"`
+	// MissingBlocks counts the linked blocks, the root included, not in the car
+	MissingBlocks int `json:"missing_blocks"`
+	// Cycles counts the links back to a block on the path from the root to
+	// them, only possible when blocks do not hash to their cid. Depth does
+	// not follow them.
+	Cycles int `json:"cycles,omitempty"`
+}
+
+// InspectReport describes the content of a car file.
+type InspectReport struct {
+	Version uint64 `json:"version"`
+	// V2 is the car v2 header, the rest of the report is about its payload
+	V2    *CarV2Header `json:"v2,omitempty"`
+	Roots []cid.Cid    `json:"roots"`
+	// Blocks is the number of sections, duplicates included
+	Blocks       int    `json:"blocks"`
+	UniqueBlocks int    `json:"unique_blocks"`
+	HeaderSize   uint64 `json:"header_size"`
+	// Size of the car data, header included and padding excluded
+	Size        uint64       `json:"size"`
+	PaddingSize uint64       `json:"padding_size"`
+	Codecs      []CodecStats `json:"codecs"`
+	Largest     []BlockInfo  `json:"largest"`
+	Duplicates  []BlockInfo  `json:"duplicates"`
+	Dags        []DagStats   `json:"dags"`
+}
+
+// InspectCar reads a car v1 stream, padded or not, and reports its header,
+// the count and bytes of its blocks per codec, the top largest blocks, the
+// duplicate blocks, the padding and the shape of the dag of every root.
+// Links are followed for dag-pb blocks. Block hashes are not checked, see
+// VerifyCar, and links closing a cycle are counted rather than followed.
+func InspectCar(r io.Reader, top int) (*InspectReport, error) {
+	cr, err := NewCarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &InspectReport{
+		Version:    cr.Header.Version,
+		Roots:      cr.Header.Roots,
+		HeaderSize: cr.HeaderSize,
+	}
+	sizes := make(map[cid.Cid]uint64)
+	links := make(map[cid.Cid][]cid.Cid)
+	blocks := make([]BlockInfo, 0)
+	codecs := make(map[uint64]*CodecStats)
+	for {
+		s, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		report.Blocks++
+		bi := BlockInfo{Cid: s.Cid, Offset: s.Offset, Size: uint64(len(s.Data))}
+		if _, ok := sizes[s.Cid]; ok {
+			report.Duplicates = append(report.Duplicates, bi)
+			continue
+		}
+		sizes[s.Cid] = bi.Size
+		blocks = append(blocks, bi)
+
+		cs, ok := codecs[s.Cid.Type()]
+		if !ok {
+			cs = &CodecStats{Codec: codecName(s.Cid.Type())}
+			codecs[s.Cid.Type()] = cs
+		}
+		cs.Blocks++
+		cs.Bytes += bi.Size
+
+		if s.Cid.Type() != cid.DagProtobuf {
+			continue
+		}
+		nd, err := merkledag.DecodeProtobuf(s.Data)
+		if err != nil {
+			continue
+		}
+		if len(nd.Links()) > 0 {
+			lks := make([]cid.Cid, 0, len(nd.Links()))
+			for _, l := range nd.Links() {
+				lks = append(lks, l.Cid)
+			}
+			links[s.Cid] = lks
+		}
+	}
+	report.UniqueBlocks = len(blocks)
+	report.Size = cr.Offset()
+	report.PaddingSize = cr.PaddingSize()
+
+	codes := make([]uint64, 0, len(codecs))
+	for code := range codecs {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	report.Codecs = make([]CodecStats, 0, len(codes))
+	for _, code := range codes {
+		report.Codecs = append(report.Codecs, *codecs[code])
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].Size > blocks[j].Size })
+	if top > len(blocks) {
+		top = len(blocks)
+	}
+	if top > 0 {
+		report.Largest = blocks[:top]
+	}
+
+	report.Dags = make([]DagStats, 0, len(report.Roots))
+	depths := make(map[cid.Cid]int)
+	for _, root := range report.Roots {
+		report.Dags = append(report.Dags, dagStats(root, sizes, links, depths))
+	}
+	return report, nil
+}
+
+// dagStats walks the blocks of the car reachable from root,
+// depths caches the depth of the dags below the blocks already seen.
+func dagStats(root cid.Cid, sizes map[cid.Cid]uint64, links map[cid.Cid][]cid.Cid, depths map[cid.Cid]int) DagStats {
+	ds := DagStats{Root: root}
+	var internal, fanouts int
+	// a block is in depths with inProgress, or in seen and not in done,
+	// while the blocks below it are walked
+	const inProgress = -1
+	var depth func(c cid.Cid) int
+	depth = func(c cid.Cid) int {
+		if d, ok := depths[c]; ok {
+			return d
+		}
+		depths[c] = inProgress
+		d := 1
+		for _, l := range links[c] {
+			if _, ok := sizes[l]; !ok {
+				continue
+			}
+			if ld := depth(l) + 1; ld > d {
+				d = ld
+			}
+		}
+		depths[c] = d
+		return d
+	}
+	seen := cid.NewSet()
+	done := cid.NewSet()
+	var visit func(c cid.Cid)
+	visit = func(c cid.Cid) {
+		if !seen.Visit(c) {
+			if !done.Has(c) {
+				ds.Cycles++
+			}
+			return
+		}
+		defer done.Add(c)
+		size, ok := sizes[c]
+		if !ok {
+			ds.MissingBlocks++
+			return
+		}
+		ds.Blocks++
+		ds.Bytes += size
+		if n := len(links[c]); n > 0 {
+			internal++
+			fanouts += n
+			if n > ds.MaxFanout {
+				ds.MaxFanout = n
+			}
+		}
+		for _, l := range links[c] {
+			visit(l)
+		}
+	}
+	visit(root)
+	if _, ok := sizes[root]; ok {
+		ds.Depth = depth(root)
+	}
+	if internal > 0 {
+		ds.AvgFanout = float64(fanouts) / float64(internal)
+	}
+	return ds
+}
+
+func codecName(code uint64) string {
+	if name, ok := cid.CodecToStr[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// InspectCarFile inspects the car file at path, for a car v2 file its car v1 payload.
+func InspectCarFile(path string, top int) (*InspectReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	finfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	h, err := ReadCarV2Header(io.NewSectionReader(f, 0, finfo.Size()))
+	if err == nil {
+		r = io.NewSectionReader(f, int64(h.DataOffset), int64(h.DataSize))
+	}
+	report, err := InspectCar(r, top)
+	if err != nil {
+		return nil, xerrors.Errorf("inspect %s: %w", path, err)
+	}
+	if h != nil {
+		report.Version = 2
+		report.V2 = h
+	}
+	return report, nil
+}
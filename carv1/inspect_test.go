@@ -0,0 +1,128 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+func TestInspectCar(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	n := tt.nodes
+	// every block once in dfs order, then f again, with b as a second root
+	car := rawCar(t, []cid.Cid{tt.root.Cid(), n["b"].Cid()}, tt.root, n["a"], n["c"], n["f"], n["g"], n["d"], n["b"], n["e"], n["f"])
+	padded := bytes.NewBuffer(car)
+	if err := PadCar(padded, int64(len(car))); err != nil {
+		t.Fatal(err)
+	}
+	report, err := InspectCar(bytes.NewReader(padded.Bytes()), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != 1 || len(report.Roots) != 2 || report.Blocks != 9 || report.UniqueBlocks != 8 {
+		t.Fatalf("%+v", report)
+	}
+	if report.Size != uint64(len(car)) || report.PaddingSize != uint64(padded.Len()-len(car)) {
+		t.Fatalf("size %d, padding %d", report.Size, report.PaddingSize)
+	}
+	if len(report.Duplicates) != 1 || !report.Duplicates[0].Cid.Equals(n["f"].Cid()) {
+		t.Fatalf("duplicates %+v", report.Duplicates)
+	}
+	if len(report.Codecs) != 2 || report.Codecs[0].Codec != "raw" || report.Codecs[0].Blocks != 4 ||
+		report.Codecs[1].Codec != "protobuf" || report.Codecs[1].Blocks != 4 {
+		t.Fatalf("codecs %+v", report.Codecs)
+	}
+	if len(report.Largest) != 3 {
+		t.Fatalf("largest %+v", report.Largest)
+	}
+	for i := 1; i < len(report.Largest); i++ {
+		if report.Largest[i].Size > report.Largest[i-1].Size {
+			t.Fatalf("largest not sorted: %+v", report.Largest)
+		}
+	}
+	for i, want := range []DagStats{
+		{Root: tt.root.Cid(), Depth: 4, Blocks: 8, MaxFanout: 2, AvgFanout: 2},
+		{Root: n["b"].Cid(), Depth: 3, Blocks: 5, MaxFanout: 2, AvgFanout: 2},
+	} {
+		got := report.Dags[i]
+		got.Bytes = 0
+		if got != want {
+			t.Fatalf("dag %d: %+v, expected %+v", i, report.Dags[i], want)
+		}
+	}
+}
+
+func TestInspectCarMissing(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	n := tt.nodes
+	report, err := InspectCar(bytes.NewReader(rawCar(t, []cid.Cid{tt.root.Cid(), n["e"].Cid()}, tt.root, n["a"], n["c"], n["d"])), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b, f and g are missing below the root, e is a missing root
+	if d := report.Dags[0]; d.Blocks != 4 || d.MissingBlocks != 3 || d.Depth != 3 {
+		t.Fatalf("%+v", d)
+	}
+	if d := report.Dags[1]; d.Blocks != 0 || d.MissingBlocks != 1 || d.Depth != 0 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+// TestInspectCarCycle inspects blocks stored under cids they do not hash to,
+// p linking to q which links back to p.
+func TestInspectCarCycle(t *testing.T) {
+	pc, err := merkledag.V1CidPrefix().Sum([]byte("not the data of p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := merkledag.NodeWithData([]byte("q"))
+	q.AddRawLink("p", &format.Link{Cid: pc})
+	p := merkledag.NodeWithData([]byte("p"))
+	if err := p.AddNodeLink("q", q); err != nil {
+		t.Fatal(err)
+	}
+	var car bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: []cid.Cid{pc, q.Cid()}, Version: 1}, &car); err != nil {
+		t.Fatal(err)
+	}
+	carutil.LdWrite(&car, pc.Bytes(), p.RawData())
+	carutil.LdWrite(&car, q.Cid().Bytes(), q.RawData())
+	report, err := InspectCar(bytes.NewReader(car.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range report.Dags {
+		if d.Blocks != 2 || d.Cycles != 1 || d.Depth < 1 || d.Depth > 2 {
+			t.Fatalf("dag %d: %+v", i, d)
+		}
+	}
+}
+
+func TestInspectCarFileV2(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	path := filepath.Join(t.TempDir(), "v2.car")
+	if err := NewBatch(ctx, dag).WriteV2ToFile(tt.root.Cid(), path, 1); err != nil {
+		t.Fatal(err)
+	}
+	report, err := InspectCarFile(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != 2 || report.V2 == nil || report.V2.IndexOffset == 0 || report.UniqueBlocks != len(tt.nodes) {
+		t.Fatalf("%+v", report)
+	}
+	if report.Size != report.V2.DataSize {
+		t.Fatalf("payload of %d bytes, header says %d", report.Size, report.V2.DataSize)
+	}
+}
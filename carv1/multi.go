@@ -0,0 +1,76 @@
+package carv1
+
+import (
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// RootRange is the part of a car file holding the blocks of one of its roots.
+// Blocks shared with an earlier root are only written, and counted, once
+// in the range of the earlier root. The ranges of the roots follow each other
+// in the order of the roots.
+type RootRange struct {
+	Root   cid.Cid
+	Offset uint64
+	Size   uint64
+	Blocks int
+}
+
+// WriteResult describes a car file written by BatchBuilder.
+type WriteResult struct {
+	// Size of the car file
+	Size  uint64
+	Roots []RootRange
+	// piece commitment of the car file, only set WithCommP
+	PieceCID cid.Cid
+	// PieceSize is the padded size of the piece
+	PieceSize uint64
+	// PayloadSize is the unpadded size of the data the piece was computed over
+	PayloadSize uint64
+}
+
+// WithCommP computes the piece commitment of the car files while they are
+// written and reports it in WriteResult. The car file does not need to be
+// padded, the piece is the same as for the file padded by PadCarFile.
+func WithCommP() BatchOption {
+	return func(b *BatchBuilder) {
+		b.commp = true
+	}
+}
+
+func (b *BatchBuilder) WriteMultiToFile(roots []cid.Cid, outPath string, batchNum int) (*WriteResult, error) {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return b.WriteMulti(roots, f, batchNum)
+}
+
+// WriteMulti writes a car v1 file holding the dags of all roots, listed in
+// the header in the given order. The dags are written one after the other,
+// blocks shared by several roots only once. With a single root it writes
+// the same file as Write and gives the full WriteResult.
+func (b *BatchBuilder) WriteMulti(roots []cid.Cid, w io.Writer, batchNum int) (*WriteResult, error) {
+	return b.write(roots, w, batchNum, nil)
+}
+
+func (b *BatchBuilder) getRoots(roots []cid.Cid) ([]format.Node, error) {
+	if len(roots) == 0 {
+		return nil, xerrors.New("no root to write")
+	}
+	nodes := make([]format.Node, 0, len(roots))
+	for _, root := range roots {
+		nd, err := b.bs.Get(b.ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, nd)
+	}
+	return nodes, nil
+}
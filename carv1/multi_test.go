@@ -0,0 +1,113 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filedrive-team/filehelper/commp"
+	"github.com/ipfs/go-cid"
+	gocar "github.com/ipld/go-car"
+)
+
+// TestWriteMatchesGoCar checks that OrderDFS car files are the ones go-car
+// writes, whatever the batch size and however many times they are written.
+func TestWriteMatchesGoCar(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	file := buildFile(t, dag, randZeroData(2, 3))
+	for name, roots := range map[string][]cid.Cid{
+		"tree":        {tt.root.Cid()},
+		"file":        {file.Cid()},
+		"shared":      {tt.nodes["a"].Cid(), tt.nodes["b"].Cid()},
+		"multi":       {file.Cid(), tt.root.Cid(), tt.nodes["c"].Cid()},
+		"nested root": {tt.nodes["c"].Cid(), tt.root.Cid()},
+	} {
+		var want bytes.Buffer
+		if err := gocar.WriteCar(ctx, dag, roots, &want); err != nil {
+			t.Fatal(err)
+		}
+		for _, batchNum := range []int{1, 3, 3, 16} {
+			var got bytes.Buffer
+			res, err := NewBatch(ctx, dag).WriteMulti(roots, &got, batchNum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Fatalf("%s, batch %d: car differs from go-car", name, batchNum)
+			}
+			if res.Size != uint64(got.Len()) {
+				t.Fatalf("%s: size %d for %d bytes", name, res.Size, got.Len())
+			}
+		}
+	}
+}
+
+func TestWriteMultiRanges(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	roots := []cid.Cid{tt.nodes["a"].Cid(), tt.nodes["b"].Cid(), tt.nodes["c"].Cid()}
+	var car bytes.Buffer
+	res, err := NewBatch(ctx, dag).WriteMulti(roots, &car, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hz, err := gocar.HeaderSize(&gocar.CarHeader{Roots: roots, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a holds c and its leaves, which b and c then share
+	blocks := []int{5, 2, 0}
+	offset := hz
+	for i, rr := range res.Roots {
+		if !rr.Root.Equals(roots[i]) || rr.Offset != offset || rr.Blocks != blocks[i] {
+			t.Fatalf("range %d: %+v, expected %d blocks at %d", i, rr, blocks[i], offset)
+		}
+		offset += rr.Size
+	}
+	if offset != res.Size || res.Size != uint64(car.Len()) {
+		t.Fatalf("ranges end at %d, car of %d bytes", offset, car.Len())
+	}
+	sections := carSections(t, car.Bytes())
+	for i, rr := range res.Roots {
+		n := 0
+		for off := range sections {
+			if off >= rr.Offset && off < rr.Offset+rr.Size {
+				n++
+			}
+		}
+		if n != rr.Blocks {
+			t.Fatalf("range %d holds %d sections, reports %d", i, n, rr.Blocks)
+		}
+	}
+}
+
+func TestWriteWithResultCommP(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	nd := buildFile(t, dag, randZeroData(2, 1))
+	var car bytes.Buffer
+	res, err := NewBatch(ctx, dag, WithCommP()).WriteWithResult(nd.Cid(), &car, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := commp.NewCalc()
+	cp.Write(car.Bytes())
+	pieceCid, pieceSize, err := cp.Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.PieceCID.Equals(pieceCid) || res.PieceSize != pieceSize || res.PayloadSize != uint64(car.Len()) {
+		t.Fatalf("%+v, expected %s of %d", res, pieceCid, pieceSize)
+	}
+	var plain bytes.Buffer
+	size, err := NewBatch(ctx, dag).Write(nd.Cid(), &plain, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != res.Size || !bytes.Equal(plain.Bytes(), car.Bytes()) {
+		t.Fatalf("Write gives %d bytes, WriteWithResult %d", size, res.Size)
+	}
+}
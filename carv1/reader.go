@@ -0,0 +1,145 @@
+package carv1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"golang.org/x/xerrors"
+)
+
+// Section is one length-delimited block frame of a car file.
+type Section struct {
+	Cid cid.Cid
+	// Offset of the frame, pointing at the length varint
+	Offset uint64
+	// Size of the whole frame, varint + cid + data
+	Size uint64
+	// DataOffset points at the block data following the cid
+	DataOffset uint64
+	Data       []byte
+}
+
+// CarReader reads the sections of a car v1 stream one by one.
+// Trailing zero bytes added by PadCar are recognized and skipped,
+// they are reported by PaddingSize once Next returns io.EOF.
+type CarReader struct {
+	br         *bufio.Reader
+	offset     uint64
+	padding    uint64
+	Header     *gocar.CarHeader
+	HeaderSize uint64
+	// the header frame as read
+	rawHeader []byte
+}
+
+func NewCarReader(r io.Reader) (*CarReader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	hb, err := carutil.LdRead(br)
+	if err != nil {
+		return nil, xerrors.Errorf("read car header: %w", err)
+	}
+	var frame bytes.Buffer
+	if err := carutil.LdWrite(&frame, hb); err != nil {
+		return nil, err
+	}
+	rawHeader := append([]byte(nil), frame.Bytes()...)
+	h, err := gocar.ReadHeader(bufio.NewReader(&frame))
+	if err != nil {
+		return nil, err
+	}
+	if h.Version != 1 {
+		return nil, xerrors.Errorf("unsupported car version: %d", h.Version)
+	}
+	hz := carutil.LdSize(hb)
+	return &CarReader{
+		br:         br,
+		offset:     hz,
+		Header:     h,
+		HeaderSize: hz,
+		rawHeader:  rawHeader,
+	}, nil
+}
+
+// Next returns the next section, or io.EOF at the end of the car data.
+func (cr *CarReader) Next() (*Section, error) {
+	if _, err := cr.br.Peek(1); err != nil {
+		return nil, err
+	}
+	l, err := binary.ReadUvarint(cr.br)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if l == 0 {
+		return nil, cr.skipPadding()
+	}
+	vz := uint64(varintSize(l))
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(cr.br, buf); err != nil {
+		return nil, xerrors.Errorf("read section at %d: %w", cr.offset, err)
+	}
+	c, n, err := readCid(buf)
+	if err != nil {
+		return nil, xerrors.Errorf("read cid at %d: %w", cr.offset, err)
+	}
+	s := &Section{
+		Cid:        c,
+		Offset:     cr.offset,
+		Size:       vz + l,
+		DataOffset: cr.offset + vz + uint64(n),
+		Data:       buf[n:],
+	}
+	cr.offset += s.Size
+	return s, nil
+}
+
+// Offset is the position right after the last section read.
+func (cr *CarReader) Offset() uint64 {
+	return cr.offset
+}
+
+// PaddingSize is the number of trailing zero bytes found after the car data.
+func (cr *CarReader) PaddingSize() uint64 {
+	return cr.padding
+}
+
+// skipPadding consumes the rest of the stream which must be all zeros,
+// the zero length varint has already been read.
+func (cr *CarReader) skipPadding() error {
+	cr.padding = 1
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := cr.br.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return xerrors.Errorf("unexpected data in car padding after offset %d", cr.offset)
+			}
+		}
+		cr.padding += uint64(n)
+		if err == io.EOF {
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readCid(buf []byte) (cid.Cid, int, error) {
+	if len(buf) < 2 || (buf[0] == 0x12 && buf[1] == 0x20 && len(buf) < 34) {
+		return cid.Undef, 0, xerrors.New("section too short for a cid")
+	}
+	return carutil.ReadCid(buf)
+}
+
+func varintSize(v uint64) int {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buf, v)
+}
@@ -0,0 +1,336 @@
+package carv1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// RefVersion is the version of the binary encoding of Carv1Ref written by RefEncoder.
+const RefVersion = 1
+
+var refMagic = []byte("FHCREF")
+
+// RefEncoder writes a Carv1Ref block by block in its binary encoding:
+//
+//	magic, version
+//	header size, root count, roots
+//	for each block: section size, gap, cid
+//	0, car size, block count
+//
+// Cids are binary, and the offset of a block is given by the gap since the
+// end of the previous one, 0 when it follows it as in the refs of BatchBuilder.
+// Section sizes are never 0, a 0 ends the blocks.
+type RefEncoder struct {
+	bw     *bufio.Writer
+	cw     *countWriter
+	next   uint64
+	blocks uint64
+}
+
+// NewRefEncoder writes the header of a ref with roots and a car header of headerSize bytes.
+func NewRefEncoder(w io.Writer, roots []cid.Cid, headerSize uint64) (*RefEncoder, error) {
+	bw := bufio.NewWriter(w)
+	e := &RefEncoder{
+		bw:   bw,
+		cw:   &countWriter{w: bw},
+		next: headerSize,
+	}
+	e.cw.Write(refMagic)
+	writeUvarint(e.cw, RefVersion)
+	writeUvarint(e.cw, headerSize)
+	writeUvarint(e.cw, uint64(len(roots)))
+	for _, root := range roots {
+		writeCid(e.cw, root)
+	}
+	return e, e.cw.err
+}
+
+// AddBlock adds the section of c at offset of size bytes, varint and cid
+// included. Sections must be added in the order of their offsets.
+func (e *RefEncoder) AddBlock(c cid.Cid, offset, size uint64) error {
+	if offset < e.next {
+		return xerrors.Errorf("block %s at %d overlaps the previous one ending at %d", c, offset, e.next)
+	}
+	if size == 0 {
+		return xerrors.Errorf("block %s has no size", c)
+	}
+	writeUvarint(e.cw, size)
+	writeUvarint(e.cw, offset-e.next)
+	writeCid(e.cw, c)
+	e.next = offset + size
+	e.blocks++
+	return e.cw.err
+}
+
+// Add adds a data ref.
+func (e *RefEncoder) Add(dr *DataRef) error {
+	if dr.Type != RefData {
+		return xerrors.Errorf("unexpected ref type %d at %d", dr.Type, dr.Offset)
+	}
+	c, err := cid.Decode(dr.Block)
+	if err != nil {
+		return err
+	}
+	return e.AddBlock(c, dr.Offset, dr.Size)
+}
+
+// Close ends the ref with the car size and flushes it, it does not close the writer.
+func (e *RefEncoder) Close() error {
+	writeUvarint(e.cw, 0)
+	writeUvarint(e.cw, e.next)
+	writeUvarint(e.cw, e.blocks)
+	if e.cw.err != nil {
+		return e.cw.err
+	}
+	return e.bw.Flush()
+}
+
+// RefDecoder reads a ref block by block. Refs in the binary encoding are
+// streamed, refs in the former cbor encoding are decoded at once.
+type RefDecoder struct {
+	Version    int
+	Roots      []cid.Cid
+	HeaderSize uint64
+
+	br     *bufio.Reader
+	next   uint64
+	blocks uint64
+	// the remaining data refs of a cbor ref
+	legacy *Carv1Ref
+	size   uint64
+	done   bool
+}
+
+func NewRefDecoder(r io.Reader) (*RefDecoder, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(refMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, refMagic) {
+		return newLegacyRefDecoder(br)
+	}
+	br.Discard(len(refMagic))
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if version != RefVersion {
+		return nil, xerrors.Errorf("unsupported car ref version: %d", version)
+	}
+	d := &RefDecoder{
+		Version: int(version),
+		br:      br,
+	}
+	if d.HeaderSize, err = binary.ReadUvarint(br); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	d.Roots = make([]cid.Cid, 0, n)
+	for i := uint64(0); i < n; i++ {
+		root, err := readCidFrom(br)
+		if err != nil {
+			return nil, xerrors.Errorf("root %d: %w", i, unexpectedEOF(err))
+		}
+		d.Roots = append(d.Roots, root)
+	}
+	d.next = d.HeaderSize
+	return d, nil
+}
+
+// newLegacyRefDecoder decodes a ref encoded by the former Carv1Ref.Encode.
+func newLegacyRefDecoder(r io.Reader) (*RefDecoder, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := decodeCborRef(data)
+	if err != nil {
+		return nil, xerrors.Errorf("not a car ref: %w", err)
+	}
+	if len(ref.DataRef) == 0 || ref.DataRef[0].Type != RefHeader {
+		return nil, xerrors.New("car ref has no header")
+	}
+	d := &RefDecoder{
+		HeaderSize: ref.DataRef[0].Size,
+		Roots:      make([]cid.Cid, 0, len(ref.DataRef[0].Blocks)),
+		legacy:     &Carv1Ref{Size: ref.Size, DataRef: ref.DataRef[1:]},
+		size:       ref.Size,
+	}
+	for _, s := range ref.DataRef[0].Blocks {
+		root, err := cid.Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		d.Roots = append(d.Roots, root)
+	}
+	return d, nil
+}
+
+// Next returns the next data ref, io.EOF after the last one.
+func (d *RefDecoder) Next() (*DataRef, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	if d.legacy != nil {
+		if len(d.legacy.DataRef) == 0 {
+			d.done = true
+			return nil, io.EOF
+		}
+		dr := d.legacy.DataRef[0]
+		d.legacy.DataRef = d.legacy.DataRef[1:]
+		return dr, nil
+	}
+	size, err := binary.ReadUvarint(d.br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if size == 0 {
+		return nil, d.readEnd()
+	}
+	gap, err := binary.ReadUvarint(d.br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	c, err := readCidFrom(d.br)
+	if err != nil {
+		return nil, xerrors.Errorf("block %d: %w", d.blocks, unexpectedEOF(err))
+	}
+	dr := &DataRef{
+		Offset: d.next + gap,
+		Size:   size,
+		Type:   RefData,
+		Block:  c.String(),
+	}
+	d.next = dr.Offset + size
+	d.blocks++
+	return dr, nil
+}
+
+func (d *RefDecoder) readEnd() error {
+	size, err := binary.ReadUvarint(d.br)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	blocks, err := binary.ReadUvarint(d.br)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if size != d.next || blocks != d.blocks {
+		return xerrors.Errorf("car ref ends with %d blocks and %d bytes, read %d blocks and %d bytes", blocks, size, d.blocks, d.next)
+	}
+	d.size = size
+	d.done = true
+	return io.EOF
+}
+
+// Size is the size of the car, known once Next returned io.EOF.
+func (d *RefDecoder) Size() uint64 {
+	return d.size
+}
+
+// Header returns the header ref of the car.
+func (d *RefDecoder) Header() *DataRef {
+	roots := make([]string, 0, len(d.Roots))
+	for _, root := range d.Roots {
+		roots = append(roots, root.String())
+	}
+	return &DataRef{
+		Size:   d.HeaderSize,
+		Type:   RefHeader,
+		Blocks: roots,
+	}
+}
+
+// WriteTo writes the ref in its binary encoding.
+func (cr *Carv1Ref) WriteTo(w io.Writer) (int64, error) {
+	if len(cr.DataRef) == 0 || cr.DataRef[0].Type != RefHeader {
+		return 0, xerrors.New("car ref has no header")
+	}
+	h := cr.DataRef[0]
+	roots := make([]cid.Cid, 0, len(h.Blocks))
+	for _, s := range h.Blocks {
+		root, err := cid.Decode(s)
+		if err != nil {
+			return 0, err
+		}
+		roots = append(roots, root)
+	}
+	cw := &countWriter{w: w}
+	e, err := NewRefEncoder(cw, roots, h.Size)
+	if err != nil {
+		return cw.n, err
+	}
+	for _, dr := range cr.DataRef[1:] {
+		if err := e.Add(dr); err != nil {
+			return cw.n, err
+		}
+	}
+	if err := e.Close(); err != nil {
+		return cw.n, err
+	}
+	if e.next != cr.Size {
+		return cw.n, xerrors.Errorf("car ref size is %d, its blocks end at %d", cr.Size, e.next)
+	}
+	return cw.n, nil
+}
+
+// ReadCarv1Ref reads a ref in the binary or the former cbor encoding.
+func ReadCarv1Ref(r io.Reader) (*Carv1Ref, error) {
+	d, err := NewRefDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	ref := &Carv1Ref{
+		DataRef: []*DataRef{d.Header()},
+	}
+	for {
+		dr, err := d.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		ref.DataRef = append(ref.DataRef, dr)
+	}
+	ref.Size = d.Size()
+	return ref, nil
+}
+
+func writeCid(w io.Writer, c cid.Cid) {
+	b := c.Bytes()
+	writeUvarint(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func readCidFrom(br *bufio.Reader) (cid.Cid, error) {
+	l, err := binary.ReadUvarint(br)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if l == 0 || l > 1<<10 {
+		return cid.Undef, xerrors.Errorf("invalid cid length: %d", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return cid.Undef, err
+	}
+	return cid.Cast(b)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
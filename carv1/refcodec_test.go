@@ -0,0 +1,189 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// testRef is the ref of the car of the test tree with a and b as second and third roots.
+func testRef(t *testing.T) (*Carv1Ref, []cid.Cid, format.DAGService) {
+	t.Helper()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	roots := []cid.Cid{tt.root.Cid(), tt.nodes["a"].Cid(), tt.nodes["b"].Cid()}
+	ref, _, err := NewBatch(context.Background(), dag).RefMulti(roots, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref, roots, dag
+}
+
+func TestRefRoundTrip(t *testing.T) {
+	ref, roots, dag := testRef(t)
+	data, err := ref.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeCarv1Ref(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ref) {
+		t.Fatalf("decoded %+v, encoded %+v", got, ref)
+	}
+
+	// WriteRef streams the same encoding
+	var streamed bytes.Buffer
+	if _, err := NewBatch(context.Background(), dag).WriteRef(roots, &streamed, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed.Bytes(), data) {
+		t.Fatal("WriteRef differs from Encode")
+	}
+
+	// sections that do not follow each other keep their offsets
+	var gaps bytes.Buffer
+	e, err := NewRefEncoder(&gaps, roots, ref.DataRef[0].Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Carv1Ref{DataRef: []*DataRef{ref.DataRef[0]}}
+	offset := ref.DataRef[0].Size
+	for i, dr := range ref.DataRef[1:] {
+		offset += uint64(i)
+		gap := &DataRef{Offset: offset, Size: dr.Size, Type: RefData, Block: dr.Block}
+		if err := e.Add(gap); err != nil {
+			t.Fatal(err)
+		}
+		want.DataRef = append(want.DataRef, gap)
+		offset += dr.Size
+	}
+	want.Size = offset
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ReadCarv1Ref(&gaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded %+v, encoded %+v", got, want)
+	}
+}
+
+// TestRefLegacy decodes refs encoded as cbor by the former Carv1Ref.Encode.
+func TestRefLegacy(t *testing.T) {
+	ref, _, _ := testRef(t)
+	data, err := cbor.Marshal(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeCarv1Ref(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ref) {
+		t.Fatalf("decoded %+v, encoded %+v", got, ref)
+	}
+	d, err := NewRefDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Version != 0 || len(d.Roots) != 3 || d.HeaderSize != ref.DataRef[0].Size {
+		t.Fatalf("%+v", d)
+	}
+
+	noHeader, err := cbor.Marshal(&Carv1Ref{Size: ref.Size, DataRef: ref.DataRef[1:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"not cbor":  []byte("not a car ref"),
+		"no header": noHeader,
+	} {
+		if _, err := DecodeCarv1Ref(data); err == nil {
+			t.Fatalf("%s: decoded", name)
+		}
+	}
+}
+
+func TestRefTruncated(t *testing.T) {
+	ref, _, _ := testRef(t)
+	data, err := ref.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(data); n++ {
+		_, err := DecodeCarv1Ref(data[:n])
+		if err == nil {
+			t.Fatalf("decoded %d of %d bytes", n, len(data))
+		}
+		// shorter than the magic it is read as a cbor ref
+		if n >= len(refMagic) && !xerrors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("%d of %d bytes: %s", n, len(data), err)
+		}
+	}
+}
+
+func uvarint(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, v)]
+}
+
+func TestRefCorrupt(t *testing.T) {
+	ref, roots, _ := testRef(t)
+	data, err := ref.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := uint64(len(ref.DataRef) - 1)
+	end := append(append([]byte{0}, uvarint(ref.Size)...), uvarint(blocks)...)
+	if !bytes.HasSuffix(data, end) {
+		t.Fatal("ref does not end with its size and block count")
+	}
+	body := data[:len(data)-len(end)]
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	// the first root starts after the magic, version, header size and root count
+	head := join(refMagic, uvarint(RefVersion), uvarint(ref.DataRef[0].Size), uvarint(1))
+	block := join(uvarint(ref.DataRef[1].Size), uvarint(0))
+	for name, corrupt := range map[string][]byte{
+		"version":         join(refMagic, uvarint(RefVersion+1), data[len(refMagic)+1:]),
+		"size":            join(body, []byte{0}, uvarint(ref.Size+1), uvarint(blocks)),
+		"block count":     join(body, []byte{0}, uvarint(ref.Size), uvarint(blocks-1)),
+		"empty root":      join(head, uvarint(0)),
+		"long root":       join(head, uvarint(1<<10+1), make([]byte, 1<<10+1)),
+		"bad root":        join(head, uvarint(3), []byte{0xff, 0xff, 0xff}),
+		"empty block cid": join(head, uvarint(uint64(len(roots[0].Bytes()))), roots[0].Bytes(), block, uvarint(0)),
+		"bad block cid":   join(head, uvarint(uint64(len(roots[0].Bytes()))), roots[0].Bytes(), block, uvarint(2), []byte{0x01, 0xff}),
+		"no block count":  join(body, []byte{0}, uvarint(ref.Size)),
+	} {
+		if _, err := DecodeCarv1Ref(corrupt); err == nil {
+			t.Fatalf("%s: decoded", name)
+		}
+	}
+
+	// refs that cannot be encoded
+	overlap := &Carv1Ref{Size: ref.Size, DataRef: append([]*DataRef{}, ref.DataRef...)}
+	overlap.DataRef[2] = &DataRef{Offset: ref.DataRef[2].Offset - 1, Size: ref.DataRef[2].Size, Type: RefData, Block: ref.DataRef[2].Block}
+	for name, bad := range map[string]*Carv1Ref{
+		"no header": {Size: ref.Size, DataRef: ref.DataRef[1:]},
+		"size":      {Size: ref.Size + 1, DataRef: ref.DataRef},
+		"overlap":   overlap,
+	} {
+		if _, err := bad.Encode(); err == nil {
+			t.Fatalf("%s: encoded", name)
+		}
+	}
+}
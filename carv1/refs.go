@@ -1,7 +1,9 @@
 package carv1
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/ipfs/go-cid"
@@ -17,69 +19,106 @@ const (
 	RefData
 )
 
+// Ref gives the layout of the car file Write makes for root, without writing it.
 func (b *BatchBuilder) Ref(root cid.Cid, batchNum int) (*Carv1Ref, error) {
-	nd, err := b.bs.Get(b.ctx, root)
+	ref, _, err := b.RefMulti([]cid.Cid{root}, batchNum)
+	return ref, err
+}
+
+// RefMulti gives the layout of the car file WriteMulti makes for roots,
+// and the range of the file taken by each root.
+func (b *BatchBuilder) RefMulti(roots []cid.Cid, batchNum int) (*Carv1Ref, []RootRange, error) {
+	ref := &Carv1Ref{}
+	ref.DataRef = make([]*DataRef, 0)
+	ranges, err := b.refWalk(roots, batchNum, func(hz uint64) error {
+		rootStrs := make([]string, 0, len(roots))
+		for _, root := range roots {
+			rootStrs = append(rootStrs, root.String())
+		}
+		ref.Size = hz
+		ref.DataRef = append(ref.DataRef, &DataRef{
+			Size:   hz,
+			Type:   RefHeader,
+			Blocks: rootStrs,
+		})
+		return nil
+	}, func(c cid.Cid, offset, size uint64) error {
+		ref.DataRef = append(ref.DataRef, &DataRef{
+			Offset: offset,
+			Size:   size,
+			Type:   RefData,
+			Block:  c.String(),
+		})
+		ref.Size += size
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fmt.Printf("car file size: %d\n", ref.Size)
+
+	return ref, ranges, nil
+}
+
+// WriteRef writes the ref RefMulti gives for roots to w in its binary
+// encoding as the blocks are walked, without holding it in memory.
+func (b *BatchBuilder) WriteRef(roots []cid.Cid, w io.Writer, batchNum int) ([]RootRange, error) {
+	var e *RefEncoder
+	ranges, err := b.refWalk(roots, batchNum, func(hz uint64) (err error) {
+		e, err = NewRefEncoder(w, roots, hz)
+		return err
+	}, func(c cid.Cid, offset, size uint64) error {
+		return e.AddBlock(c, offset, size)
+	})
 	if err != nil {
 		return nil, err
 	}
-	ref := &Carv1Ref{}
-	ref.DataRef = make([]*DataRef, 0)
+	return ranges, e.Close()
+}
 
+// refWalk calls header with the size of the car header, then block with
+// the section of each uniq block once, in the same order as Write.
+func (b *BatchBuilder) refWalk(roots []cid.Cid, batchNum int, header func(hz uint64) error, block func(c cid.Cid, offset, size uint64) error) ([]RootRange, error) {
+	nodes, err := b.getRoots(roots)
+	if err != nil {
+		return nil, err
+	}
 	h := &gocar.CarHeader{
-		Roots:   []cid.Cid{root},
+		Roots:   roots,
 		Version: 1,
 	}
 	hz, err := gocar.HeaderSize(h)
 	if err != nil {
 		return nil, err
 	}
+	if err := header(hz); err != nil {
+		return nil, err
+	}
 
-	ref.DataRef = append(ref.DataRef, &DataRef{
-		Size:   hz,
-		Type:   RefHeader,
-		Blocks: []string{root.String()},
-	})
-	ref.Size += hz
-
-	// set cid set to only save uniq cid to car file
-	cidSet := cid.NewSet()
-	cidSet.Add(nd.Cid())
-
-	// ref root node
-	rootSize := carutil.LdSize(nd.Cid().Bytes(), nd.RawData())
-
-	ref.DataRef = append(ref.DataRef, &DataRef{
-		Offset: ref.Size,
-		Size:   rootSize,
-		Type:   RefData,
-		Block:  root.String(),
-	})
-	ref.Size += rootSize
-
-	//fmt.Printf("cid: %s\n", nd.Cid())
-	if err := BlockWalk(b.ctx, nd, b.bs, batchNum, func(node format.Node) error {
-		if cidSet.Has(node.Cid()) {
+	offset := hz
+	ranges := make([]RootRange, 0, len(roots))
+	seen := cid.NewSet()
+	for _, nd := range nodes {
+		rr := RootRange{
+			Root:   nd.Cid(),
+			Offset: offset,
+		}
+		if err := b.walk(nd, batchNum, seen, func(node format.Node) error {
+			bsize := carutil.LdSize(node.Cid().Bytes(), node.RawData())
+			if err := block(node.Cid(), offset, bsize); err != nil {
+				return err
+			}
+			offset += bsize
+			rr.Blocks++
 			return nil
+		}); err != nil {
+			return nil, err
 		}
-
-		cidSet.Add(node.Cid())
-		bsize := carutil.LdSize(node.Cid().Bytes(), node.RawData())
-
-		ref.DataRef = append(ref.DataRef, &DataRef{
-			Offset: ref.Size,
-			Size:   bsize,
-			Type:   RefData,
-			Block:  node.Cid().String(),
-		})
-		ref.Size += bsize
-		return nil
-	}); err != nil {
-		return nil, err
+		rr.Size = offset - rr.Offset
+		ranges = append(ranges, rr)
 	}
-
-	fmt.Printf("car file size: %d\n", ref.Size)
-
-	return ref, nil
+	return ranges, nil
 }
 
 type Carv1Ref struct {
@@ -95,11 +134,21 @@ type DataRef struct {
 	Block  string
 }
 
+// Encode gives the binary encoding of the ref, see RefEncoder.
 func (cr *Carv1Ref) Encode() ([]byte, error) {
-	return cbor.Marshal(cr)
+	var buf bytes.Buffer
+	if _, err := cr.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeCarv1Ref decodes a ref in the binary encoding or the former cbor one.
+func DecodeCarv1Ref(data []byte) (*Carv1Ref, error) {
+	return ReadCarv1Ref(bytes.NewReader(data))
 }
 
-func DecodeCarv1Ref(data []byte) (ref *Carv1Ref, err error) {
+func decodeCborRef(data []byte) (ref *Carv1Ref, err error) {
 	ref = &Carv1Ref{}
 	err = cbor.Unmarshal(data, ref)
 	return
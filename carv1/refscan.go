@@ -0,0 +1,197 @@
+package carv1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	gocar "github.com/ipld/go-car"
+	"golang.org/x/xerrors"
+)
+
+// RefFromCar scans a car v1 stream, padded or not, and gives its Carv1Ref:
+// the ref of every section in the order of the car, duplicates included, so
+// that a VirtualCar of the ref gives back the car without its padding.
+// Cars whose header would not be written back identically are rejected,
+// CheckRef confirms the rest of the car agrees with the ref.
+func RefFromCar(r io.Reader) (*Carv1Ref, error) {
+	cr, err := NewCarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var header bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: cr.Header.Roots, Version: 1}, &header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header.Bytes(), cr.rawHeader) {
+		return nil, xerrors.New("car header is not in its canonical encoding")
+	}
+	roots := make([]string, 0, len(cr.Header.Roots))
+	for _, root := range cr.Header.Roots {
+		roots = append(roots, root.String())
+	}
+	ref := &Carv1Ref{
+		Size: cr.HeaderSize,
+		DataRef: []*DataRef{{
+			Size:   cr.HeaderSize,
+			Type:   RefHeader,
+			Blocks: roots,
+		}},
+	}
+	for {
+		s, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		ref.DataRef = append(ref.DataRef, &DataRef{
+			Offset: s.Offset,
+			Size:   s.Size,
+			Type:   RefData,
+			Block:  s.Cid.String(),
+		})
+		ref.Size += s.Size
+	}
+	return ref, nil
+}
+
+// RefFromCarFile is RefFromCar for the car file at path, for a car v2 file its car v1 payload.
+func RefFromCarFile(path string) (*Carv1Ref, error) {
+	f, r, err := openCarPayload(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ref, err := RefFromCar(r)
+	if err != nil {
+		return nil, xerrors.Errorf("ref %s: %w", path, err)
+	}
+	return ref, nil
+}
+
+// CheckRef reads a car v1 stream and checks it is byte for byte the car of
+// ref, followed by zero padding or nothing: the header is the one of the
+// roots of ref, every section is at the offset of its ref, holds its cid with
+// a data matching it, and has its size.
+func CheckRef(ref *Carv1Ref, r io.Reader) error {
+	if len(ref.DataRef) == 0 || ref.DataRef[0].Type != RefHeader {
+		return xerrors.New("car ref has no header")
+	}
+	roots := make([]cid.Cid, 0, len(ref.DataRef[0].Blocks))
+	for _, s := range ref.DataRef[0].Blocks {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, c)
+	}
+	var header bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, &header); err != nil {
+		return err
+	}
+	if uint64(header.Len()) != ref.DataRef[0].Size {
+		return xerrors.Errorf("car header is %d bytes, ref says %d", header.Len(), ref.DataRef[0].Size)
+	}
+	br := bufio.NewReaderSize(r, 1<<20)
+	buf := make([]byte, header.Len())
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return xerrors.Errorf("read car header: %w", err)
+	}
+	if !bytes.Equal(buf, header.Bytes()) {
+		return xerrors.New("car header differs from the ref")
+	}
+	offset := uint64(header.Len())
+	for _, dr := range ref.DataRef[1:] {
+		if dr.Type != RefData {
+			return xerrors.Errorf("unexpected ref type %d at %d", dr.Type, dr.Offset)
+		}
+		if dr.Offset != offset {
+			return xerrors.Errorf("ref of %s at %d, the previous one ends at %d", dr.Block, dr.Offset, offset)
+		}
+		c, err := cid.Decode(dr.Block)
+		if err != nil {
+			return err
+		}
+		if err := checkSection(br, c, dr.Size); err != nil {
+			return xerrors.Errorf("section at %d: %w", offset, err)
+		}
+		offset += dr.Size
+	}
+	if offset != ref.Size {
+		return xerrors.Errorf("car ref size is %d, its blocks end at %d", ref.Size, offset)
+	}
+	for {
+		n, err := br.Read(buf[:cap(buf)])
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return xerrors.Errorf("unexpected data after the car at %d", offset)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkSection reads a section of size bytes holding c.
+func checkSection(br *bufio.Reader, c cid.Cid, size uint64) error {
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		return err
+	}
+	l, vz := binary.Uvarint(frame)
+	if vz <= 0 || vz != varintSize(l) || uint64(vz)+l != size {
+		return xerrors.Errorf("section length does not make %d bytes for %s", size, c)
+	}
+	cb := c.Bytes()
+	if !bytes.HasPrefix(frame[vz:], cb) {
+		return xerrors.Errorf("section does not hold %s", c)
+	}
+	sum, err := c.Prefix().Sum(frame[vz+len(cb):])
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return xerrors.Errorf("section data does not match %s", c)
+	}
+	return nil
+}
+
+// CheckRefFile is CheckRef for the car file at path, for a car v2 file its car v1 payload.
+func CheckRefFile(ref *Carv1Ref, path string) error {
+	f, r, err := openCarPayload(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := CheckRef(ref, r); err != nil {
+		return xerrors.Errorf("check %s: %w", path, err)
+	}
+	return nil
+}
+
+// openCarPayload opens the car file at path and gives a reader of its car v1 data.
+func openCarPayload(path string) (*os.File, io.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	finfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	var r io.Reader = f
+	if h, err := ReadCarV2Header(io.NewSectionReader(f, 0, finfo.Size())); err == nil {
+		r = io.NewSectionReader(f, int64(h.DataOffset), int64(h.DataSize))
+	}
+	return f, r, nil
+}
@@ -0,0 +1,135 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestRefFromCar(t *testing.T) {
+	ctx := context.Background()
+	dag, bs := newStoreDAG()
+	tt := buildTestTree(t, dag)
+	file := buildFile(t, dag, randZeroData(1, 2))
+	dir := t.TempDir()
+	for _, root := range []cid.Cid{tt.root.Cid(), file.Cid()} {
+		for _, order := range []Order{OrderDFS, OrderBFS} {
+			b := NewBatch(ctx, dag, WithOrder(order))
+			want, err := b.Ref(root, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var car bytes.Buffer
+			size, err := b.Write(root, &car, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			padded := bytes.NewBuffer(append([]byte{}, car.Bytes()...))
+			if err := PadCar(padded, int64(size)); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range map[string][]byte{"car": car.Bytes(), "padded": padded.Bytes()} {
+				ref, err := RefFromCar(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(ref, want) {
+					t.Fatalf("%s, %s: ref %+v, builder ref %+v", order, name, ref, want)
+				}
+				if err := CheckRef(want, bytes.NewReader(data)); err != nil {
+					t.Fatalf("%s, %s: %s", order, name, err)
+				}
+			}
+
+			v2 := filepath.Join(dir, "v2.car")
+			if err := b.WriteV2ToFile(root, v2, 1); err != nil {
+				t.Fatal(err)
+			}
+			ref, err := RefFromCarFile(v2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ref, want) {
+				t.Fatalf("%s, v2: ref %+v, builder ref %+v", order, ref, want)
+			}
+			if err := CheckRefFile(want, v2); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// duplicates stay in the ref, the virtual car of the ref is the car
+	n := tt.nodes
+	car := rawCar(t, []cid.Cid{tt.root.Cid()}, tt.root, n["a"], n["c"], n["f"], n["g"], n["d"], n["b"], n["f"], n["e"])
+	ref, err := RefFromCar(bytes.NewReader(car))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ref.DataRef) != 10 || ref.Size != uint64(len(car)) {
+		t.Fatalf("%d refs of %d bytes for a car of 9 blocks and %d bytes", len(ref.DataRef), ref.Size, len(car))
+	}
+	vc, err := NewVirtualCar(ref, bs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(vc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, car) {
+		t.Fatal("virtual car differs from the car")
+	}
+}
+
+func TestCheckRefChanged(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	b := NewBatch(ctx, dag)
+	ref, err := b.Ref(tt.root.Cid(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var car bytes.Buffer
+	if _, err := b.Write(tt.root.Cid(), &car, 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < car.Len(); i++ {
+		changed := append([]byte{}, car.Bytes()...)
+		changed[i] ^= 0x01
+		if err := CheckRef(ref, bytes.NewReader(changed)); err == nil {
+			t.Fatalf("checked a car with byte %d of %d changed", i, car.Len())
+		}
+	}
+	for name, data := range map[string][]byte{
+		"truncated": car.Bytes()[:car.Len()-1],
+		"trailing":  append(append([]byte{}, car.Bytes()...), 0, 1),
+	} {
+		if err := CheckRef(ref, bytes.NewReader(data)); err == nil {
+			t.Fatalf("%s: checked", name)
+		}
+	}
+	other, err := b.Ref(tt.nodes["a"].Cid(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRef(other, bytes.NewReader(car.Bytes())); err == nil {
+		t.Fatal("checked the car against the ref of another root")
+	}
+
+	path := filepath.Join(t.TempDir(), "changed.car")
+	changed := append([]byte{}, car.Bytes()...)
+	changed[len(changed)-1] ^= 0x01
+	if err := os.WriteFile(path, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRefFile(ref, path); err == nil {
+		t.Fatal("checked a changed car file")
+	}
+}
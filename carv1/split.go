@@ -0,0 +1,383 @@
+package carv1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"golang.org/x/xerrors"
+)
+
+// SplitManifest maps a root to the car files its dag was split into.
+type SplitManifest struct {
+	Root string `json:"root"`
+	// TargetSize is the size no car file of the split exceeds
+	TargetSize uint64     `json:"target_size"`
+	Cars       []*CarPart `json:"cars"`
+}
+
+// CarPart is one car file of a split. The header of the car lists the roots
+// of the subgraphs it holds, then the partial roots: nodes too large to fit
+// in a car with their descendants, written alone, whose descendants are in
+// the same or later cars. Every block is in a single car, the blocks of a
+// subgraph already written to an earlier car are not repeated.
+type CarPart struct {
+	Path    string   `json:"path"`
+	Size    uint64   `json:"size"`
+	Roots   []string `json:"roots"`
+	Partial []string `json:"partial,omitempty"`
+	// piece commitment of the car, set when the builder has WithCommP
+	PieceCID  string `json:"piece_cid,omitempty"`
+	PieceSize uint64 `json:"piece_size,omitempty"`
+}
+
+// planNode is what the split planning keeps of a block.
+type planNode struct {
+	section uint64
+	links   []cid.Cid
+	// placed is set once the block is assigned to a car
+	placed bool
+}
+
+type partPlan struct {
+	roots []cid.Cid
+	// blocks[i] are the blocks written for roots[i], in write order
+	blocks  [][]cid.Cid
+	partial []cid.Cid
+	size    uint64
+}
+
+// Split writes the dag of root into car files of at most targetSize bytes,
+// named <root>-<n>.car in outDir, and returns the manifest of the split.
+// Subgraphs are packed in the order of the builder, a node whose subgraph
+// does not fit in a car on its own is written alone and its children are
+// packed after it. Blocks shared by several subgraphs are written once, with
+// the first subgraph, and only the blocks not yet packed count towards the
+// size of a subgraph. The dag is walked twice: once to measure the blocks,
+// once to write them.
+func (b *BatchBuilder) Split(root cid.Cid, targetSize uint64, outDir string, batchNum int) (*SplitManifest, error) {
+	nodes := make(map[cid.Cid]*planNode)
+	if err := b.measure(root, batchNum, nodes); err != nil {
+		return nil, err
+	}
+	parts, err := packParts(root, targetSize, nodes, b.order)
+	if err != nil {
+		return nil, err
+	}
+	m := &SplitManifest{
+		Root:       root.String(),
+		TargetSize: targetSize,
+		Cars:       make([]*CarPart, 0, len(parts)),
+	}
+	for i, pp := range parts {
+		part, err := b.writePart(pp, filepath.Join(outDir, fmt.Sprintf("%s-%d.car", root, i)), batchNum)
+		if err != nil {
+			return nil, err
+		}
+		if part.Size > targetSize {
+			return nil, xerrors.Errorf("car %s of %d bytes exceeds the target size", part.Path, part.Size)
+		}
+		m.Cars = append(m.Cars, part)
+	}
+	return m, nil
+}
+
+// measure records the section size and links of every block of the dag of root.
+func (b *BatchBuilder) measure(root cid.Cid, batchNum int, nodes map[cid.Cid]*planNode) error {
+	nd, err := b.bs.Get(b.ctx, root)
+	if err != nil {
+		return err
+	}
+	return b.walk(nd, batchNum, cid.NewSet(), func(node format.Node) error {
+		pn := &planNode{
+			section: carutil.LdSize(node.Cid().Bytes(), node.RawData()),
+			links:   make([]cid.Cid, 0, len(node.Links())),
+		}
+		for _, l := range node.Links() {
+			pn.links = append(pn.links, l.Cid)
+		}
+		nodes[node.Cid()] = pn
+		return nil
+	})
+}
+
+// unplaced returns the blocks of the subgraph of c not yet placed, each once
+// and in order, with the size of their sections. The descendants of a placed
+// block are placed too, or are being placed as the children of a partial root
+// above c, so the walk does not go through placed blocks.
+func unplaced(c cid.Cid, nodes map[cid.Cid]*planNode, order Order) ([]cid.Cid, uint64) {
+	var res []cid.Cid
+	var size uint64
+	visited := cid.NewSet()
+	visit := func(c cid.Cid) bool {
+		if nodes[c].placed || !visited.Visit(c) {
+			return false
+		}
+		res = append(res, c)
+		size += nodes[c].section
+		return true
+	}
+	if !visit(c) {
+		return nil, 0
+	}
+	if order == OrderBFS {
+		for i := 0; i < len(res); i++ {
+			for _, l := range nodes[res[i]].links {
+				visit(l)
+			}
+		}
+		return res, size
+	}
+	var dfs func(c cid.Cid)
+	dfs = func(c cid.Cid) {
+		for _, l := range nodes[c].links {
+			if visit(l) {
+				dfs(l)
+			}
+		}
+	}
+	dfs(c)
+	return res, size
+}
+
+// packParts assigns the blocks of the dag of root to car files of at most targetSize bytes.
+func packParts(root cid.Cid, targetSize uint64, nodes map[cid.Cid]*planNode, order Order) ([]*partPlan, error) {
+	parts := []*partPlan{{}}
+	base, err := gocar.HeaderSize(&gocar.CarHeader{Roots: []cid.Cid{}, Version: 1})
+	if err != nil {
+		return nil, err
+	}
+	// upper bound of the header size once c is added to the roots of pp:
+	// cid with its multibase prefix, tag and length, plus the growth of
+	// the array and frame lengths
+	headerWith := func(pp *partPlan, c cid.Cid) uint64 {
+		n := base + 2*8
+		for _, r := range pp.roots {
+			n += uint64(len(r.Bytes())) + 6
+		}
+		for _, r := range pp.partial {
+			n += uint64(len(r.Bytes())) + 6
+		}
+		return n + uint64(len(c.Bytes())) + 6
+	}
+	fits := func(pp *partPlan, c cid.Cid, size uint64) bool {
+		return headerWith(pp, c)+pp.size+size <= targetSize
+	}
+	placeAll := func(pp *partPlan, c cid.Cid, blocks []cid.Cid, size uint64) {
+		pp.roots = append(pp.roots, c)
+		pp.blocks = append(pp.blocks, blocks)
+		pp.size += size
+		for _, bc := range blocks {
+			nodes[bc].placed = true
+		}
+	}
+	var place func(c cid.Cid) error
+	place = func(c cid.Cid) error {
+		pn := nodes[c]
+		if pn.placed {
+			// shared with a subgraph packed earlier
+			return nil
+		}
+		blocks, size := unplaced(c, nodes, order)
+		cur := parts[len(parts)-1]
+		empty := &partPlan{}
+		switch {
+		case fits(cur, c, size):
+			placeAll(cur, c, blocks, size)
+			return nil
+		case fits(empty, c, size):
+			cur = &partPlan{}
+			parts = append(parts, cur)
+			placeAll(cur, c, blocks, size)
+			return nil
+		case !fits(empty, c, pn.section):
+			return xerrors.Errorf("block %s of %d bytes does not fit in a car of %d bytes", c, pn.section, targetSize)
+		}
+		if !fits(cur, c, pn.section) {
+			cur = &partPlan{}
+			parts = append(parts, cur)
+		}
+		cur.partial = append(cur.partial, c)
+		cur.size += pn.section
+		pn.placed = true
+		for _, l := range pn.links {
+			if err := place(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := place(root); err != nil {
+		return nil, err
+	}
+	if len(parts[0].roots) == 0 && len(parts[0].partial) == 0 {
+		parts = parts[1:]
+	}
+	return parts, nil
+}
+
+// writePart writes the blocks planned for the roots then the partial roots of pp to outPath.
+func (b *BatchBuilder) writePart(pp *partPlan, outPath string, batchNum int) (*CarPart, error) {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	roots := append(append([]cid.Cid{}, pp.roots...), pp.partial...)
+	part := &CarPart{
+		Path:    outPath,
+		Roots:   cidStrings(pp.roots),
+		Partial: cidStrings(pp.partial),
+	}
+	// partial roots are written without their descendants
+	res, err := b.writeWith(roots, f, func(nd format.Node, i int, _ *cid.Set, cb func(format.Node) error) error {
+		if err := cb(nd); err != nil || i >= len(pp.roots) {
+			return err
+		}
+		blocks := pp.blocks[i][1:]
+		if batchNum < 1 {
+			batchNum = 1
+		}
+		for start := 0; start < len(blocks); start += batchNum {
+			end := start + batchNum
+			if end > len(blocks) {
+				end = len(blocks)
+			}
+			nodes, err := getNodes(b.ctx, b.bs, blocks[start:end], batchNum)
+			if err != nil {
+				return err
+			}
+			for _, node := range nodes {
+				if err := cb(node); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	part.Size = res.Size
+	part.setPiece(res)
+	return part, f.Close()
+}
+
+func (part *CarPart) setPiece(res *WriteResult) {
+	if res.PieceCID.Defined() {
+		part.PieceCID = res.PieceCID.String()
+		part.PieceSize = res.PieceSize
+	}
+}
+
+func cidStrings(cids []cid.Cid) []string {
+	res := make([]string, 0, len(cids))
+	for _, c := range cids {
+		res = append(res, c.String())
+	}
+	return res
+}
+
+// Save writes the manifest as json to path.
+func (m *SplitManifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func LoadSplitManifest(path string) (*SplitManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &SplitManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SplitNodeGetter serves the blocks of the car files of a split.
+type SplitNodeGetter struct {
+	getters []*CarNodeGetter
+}
+
+// OpenSplitNodeGetter opens every car file of m.
+func OpenSplitNodeGetter(m *SplitManifest) (*SplitNodeGetter, error) {
+	sg := &SplitNodeGetter{}
+	for _, part := range m.Cars {
+		ng, err := OpenCarNodeGetter(part.Path)
+		if err != nil {
+			sg.Close()
+			return nil, xerrors.Errorf("open %s: %w", part.Path, err)
+		}
+		sg.getters = append(sg.getters, ng)
+	}
+	return sg, nil
+}
+
+func (sg *SplitNodeGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	for _, ng := range sg.getters {
+		if ng.Has(c) {
+			return ng.Get(ctx, c)
+		}
+	}
+	return nil, format.ErrNotFound
+}
+
+func (sg *SplitNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for _, c := range cids {
+			nd, err := sg.Get(ctx, c)
+			select {
+			case out <- &format.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (sg *SplitNodeGetter) Close() error {
+	var err error
+	for _, ng := range sg.getters {
+		if cerr := ng.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Recombine writes the whole dag of the split m as a single car file to
+// outPath, the same file BatchBuilder.Write gives for the original root.
+func Recombine(ctx context.Context, m *SplitManifest, outPath string, batchNum int, opts ...BatchOption) error {
+	_, err := RecombineWithResult(ctx, m, outPath, batchNum, opts...)
+	return err
+}
+
+// RecombineWithResult is Recombine returning the WriteResult of the car file.
+func RecombineWithResult(ctx context.Context, m *SplitManifest, outPath string, batchNum int, opts ...BatchOption) (*WriteResult, error) {
+	root, err := cid.Decode(m.Root)
+	if err != nil {
+		return nil, err
+	}
+	sg, err := OpenSplitNodeGetter(m)
+	if err != nil {
+		return nil, err
+	}
+	defer sg.Close()
+	return NewBatch(ctx, sg, opts...).WriteToFileWithResult(root, outPath, batchNum)
+}
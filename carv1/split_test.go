@@ -0,0 +1,183 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// buildSharedDag builds a root over 4 nodes of 4 leaves of 10KB each,
+// consecutive nodes sharing 2 leaves, the second node linked twice.
+func buildSharedDag(t *testing.T, dag format.DAGService) format.Node {
+	t.Helper()
+	ctx := context.Background()
+	leaves := make([]format.Node, 10)
+	for i := range leaves {
+		data := make([]byte, 10<<10)
+		rand.Read(data)
+		leaves[i] = merkledag.NewRawNode(data)
+		if err := dag.Add(ctx, leaves[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := merkledag.NodeWithData([]byte("root"))
+	root.SetCidBuilder(merkledag.V1CidPrefix())
+	var second format.Node
+	for i := 0; i < 4; i++ {
+		nd := merkledag.NodeWithData([]byte{byte(i)})
+		nd.SetCidBuilder(merkledag.V1CidPrefix())
+		for _, leaf := range leaves[2*i : 2*i+4] {
+			if err := nd.AddNodeLink("", leaf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dag.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink("", nd); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			second = nd
+		}
+	}
+	if err := root.AddNodeLink("", second); err != nil {
+		t.Fatal(err)
+	}
+	if err := dag.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestSplitShared(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	shared := buildSharedDag(t, dag)
+	file := buildFile(t, dag, randZeroData(2, 3))
+	for _, tc := range []struct {
+		name    string
+		root    format.Node
+		targets []uint64
+	}{
+		{"shared", shared, []uint64{25 << 10, 35 << 10, 60 << 10, 200 << 10}},
+		{"zero leaves", file, []uint64{1<<20 + 1<<10, 2<<20 + 1<<10, 3 << 20, 8 << 20}},
+	} {
+		var all []cid.Cid
+		if err := NewBatch(ctx, dag).walk(tc.root, 1, cid.NewSet(), func(nd format.Node) error {
+			all = append(all, nd.Cid())
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for _, order := range []Order{OrderDFS, OrderBFS} {
+			var want bytes.Buffer
+			if _, err := NewBatch(ctx, dag, WithOrder(order)).Write(tc.root.Cid(), &want, 1); err != nil {
+				t.Fatal(err)
+			}
+			for _, target := range tc.targets {
+				dir := t.TempDir()
+				m, err := NewBatch(ctx, dag, WithOrder(order)).Split(tc.root.Cid(), target, dir, 2)
+				if err != nil {
+					t.Fatalf("%s, %s, %d: %s", tc.name, order, target, err)
+				}
+				// every block in exactly one car
+				carOf := make(map[cid.Cid]int)
+				var total uint64
+				for i, part := range m.Cars {
+					if part.Size > target {
+						t.Fatalf("%s: car %d of %d bytes", tc.name, i, part.Size)
+					}
+					f, err := os.Open(part.Path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					report, err := VerifyCar(f)
+					f.Close()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(report.BadHashes) > 0 || len(report.Duplicates) > 0 || len(report.MissingRoots) > 0 || len(report.Orphans) > 0 {
+						t.Fatalf("%s: car %d: %+v", tc.name, i, report)
+					}
+					if report.Size != part.Size {
+						t.Fatalf("%s: car %d of %d bytes, manifest says %d", tc.name, i, report.Size, part.Size)
+					}
+					total += part.Size
+					sections := readSections(t, part.Path)
+					for _, c := range sections {
+						if j, ok := carOf[c]; ok {
+							t.Fatalf("%s, %s, %d: %s in cars %d and %d", tc.name, order, target, c, j, i)
+						}
+						carOf[c] = i
+					}
+				}
+				if len(carOf) != len(all) {
+					t.Fatalf("%s, %s, %d: %d blocks in the cars, %d in the dag", tc.name, order, target, len(carOf), len(all))
+				}
+				if target == tc.targets[len(tc.targets)-1] && len(m.Cars) != 1 {
+					t.Fatalf("%s: %d cars for a dag of %d bytes", tc.name, len(m.Cars), want.Len())
+				}
+
+				manifest := filepath.Join(dir, "manifest.json")
+				if err := m.Save(manifest); err != nil {
+					t.Fatal(err)
+				}
+				loaded, err := LoadSplitManifest(manifest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sg, err := OpenSplitNodeGetter(loaded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, c := range all {
+					if _, err := sg.Get(ctx, c); err != nil {
+						t.Fatal(err)
+					}
+				}
+				sg.Close()
+				out := filepath.Join(dir, "recombined.car")
+				if err := Recombine(ctx, loaded, out, 2, WithOrder(order)); err != nil {
+					t.Fatal(err)
+				}
+				got, err := os.ReadFile(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want.Bytes()) {
+					t.Fatalf("%s, %s, %d: recombined car differs from Write", tc.name, order, target)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitBlockTooLarge(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	nd := buildSharedDag(t, dag)
+	if _, err := NewBatch(ctx, dag).Split(nd.Cid(), 8<<10, t.TempDir(), 1); err == nil {
+		t.Fatal("split blocks larger than the target size")
+	}
+}
+
+func readSections(t *testing.T, path string) []cid.Cid {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []cid.Cid
+	for _, s := range carSections(t, data) {
+		res = append(res, s.Cid)
+	}
+	return res
+}
@@ -0,0 +1,197 @@
+package carv1
+
+import (
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+)
+
+// BlockIssue is a block of a car file failing verification.
+type BlockIssue struct {
+	Cid cid.Cid
+	// Offset of the section of the block
+	Offset uint64
+	Err    string `json:",omitempty"`
+}
+
+// MissingLink is a link of a dag-pb node to a block not in the car file.
+type MissingLink struct {
+	Parent cid.Cid
+	Link   cid.Cid
+}
+
+// VerifyReport is the result of the verification of a car file.
+type VerifyReport struct {
+	Roots []cid.Cid
+	// Blocks is the number of sections, duplicates included
+	Blocks int
+	// Size of the car data, header included and padding excluded
+	Size        uint64
+	PaddingSize uint64
+	// BadHashes are the blocks whose data does not hash to their cid
+	BadHashes []BlockIssue
+	// Undecodable are the dag-pb blocks whose links could not be read
+	Undecodable []BlockIssue
+	// Duplicates are the sections of blocks already found earlier in the file
+	Duplicates   []BlockIssue
+	MissingRoots []cid.Cid
+	MissingLinks []MissingLink
+	// Orphans are the blocks not reachable from any root
+	Orphans []cid.Cid
+}
+
+// OK reports whether the car file passed every check.
+func (r *VerifyReport) OK() bool {
+	return len(r.BadHashes) == 0 && len(r.Undecodable) == 0 && len(r.Duplicates) == 0 &&
+		len(r.MissingRoots) == 0 && len(r.MissingLinks) == 0 && len(r.Orphans) == 0
+}
+
+// VerifyCar reads a car v1 stream, padded or not, and checks that every
+// block hashes to its cid, that every root and every link of every dag-pb
+// node is in the car, and that there is no duplicate nor orphan block.
+// Defects are listed in the report, the error is only set when the car
+// cannot be read to its end.
+func VerifyCar(r io.Reader) (*VerifyReport, error) {
+	cr, err := NewCarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{
+		Roots: cr.Header.Roots,
+	}
+	present := make(map[cid.Cid]struct{})
+	order := make([]cid.Cid, 0)
+	links := make(map[cid.Cid][]cid.Cid)
+	for {
+		s, err := cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		report.Blocks++
+		// every copy of a block is hashed, a later one may be the corrupted one
+		if sum, err := s.Cid.Prefix().Sum(s.Data); err != nil {
+			report.BadHashes = append(report.BadHashes, BlockIssue{Cid: s.Cid, Offset: s.Offset, Err: err.Error()})
+		} else if !sum.Equals(s.Cid) {
+			report.BadHashes = append(report.BadHashes, BlockIssue{Cid: s.Cid, Offset: s.Offset, Err: "hash mismatch"})
+		}
+		if _, ok := present[s.Cid]; ok {
+			report.Duplicates = append(report.Duplicates, BlockIssue{Cid: s.Cid, Offset: s.Offset})
+			continue
+		}
+		present[s.Cid] = struct{}{}
+		order = append(order, s.Cid)
+
+		if s.Cid.Type() != cid.DagProtobuf {
+			continue
+		}
+		nd, err := merkledag.DecodeProtobuf(s.Data)
+		if err != nil {
+			report.Undecodable = append(report.Undecodable, BlockIssue{Cid: s.Cid, Offset: s.Offset, Err: err.Error()})
+			continue
+		}
+		if len(nd.Links()) > 0 {
+			lks := make([]cid.Cid, 0, len(nd.Links()))
+			for _, l := range nd.Links() {
+				lks = append(lks, l.Cid)
+			}
+			links[s.Cid] = lks
+		}
+	}
+	report.Size = cr.Offset()
+	report.PaddingSize = cr.PaddingSize()
+
+	// identity cids hold their data, car writers do not store them as blocks
+	has := func(c cid.Cid) bool {
+		if _, ok := present[c]; ok {
+			return true
+		}
+		return c.Prefix().MhType == multihash.IDENTITY
+	}
+	for _, c := range order {
+		for _, l := range links[c] {
+			if !has(l) {
+				report.MissingLinks = append(report.MissingLinks, MissingLink{Parent: c, Link: l})
+			}
+		}
+	}
+	// links of c, decoded from the cid for an identity dag-pb block not stored
+	linksOf := func(c cid.Cid) []cid.Cid {
+		if _, ok := present[c]; ok || c.Type() != cid.DagProtobuf || c.Prefix().MhType != multihash.IDENTITY {
+			return links[c]
+		}
+		dmh, err := multihash.Decode(c.Hash())
+		if err != nil {
+			return nil
+		}
+		nd, err := merkledag.DecodeProtobuf(dmh.Digest)
+		if err != nil {
+			return nil
+		}
+		lks := make([]cid.Cid, 0, len(nd.Links()))
+		for _, l := range nd.Links() {
+			lks = append(lks, l.Cid)
+		}
+		return lks
+	}
+	// walk the blocks reachable from the roots
+	reached := make(map[cid.Cid]struct{})
+	queue := make([]cid.Cid, 0)
+	for _, root := range report.Roots {
+		if !has(root) {
+			report.MissingRoots = append(report.MissingRoots, root)
+			continue
+		}
+		if _, ok := reached[root]; !ok {
+			reached[root] = struct{}{}
+			queue = append(queue, root)
+		}
+	}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, l := range linksOf(c) {
+			if !has(l) {
+				continue
+			}
+			if _, ok := reached[l]; !ok {
+				reached[l] = struct{}{}
+				queue = append(queue, l)
+			}
+		}
+	}
+	for _, c := range order {
+		if _, ok := reached[c]; !ok {
+			report.Orphans = append(report.Orphans, c)
+		}
+	}
+	return report, nil
+}
+
+// VerifyCarFile verifies the car file at path, for a car v2 file its car v1 payload.
+func VerifyCarFile(path string) (*VerifyReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	finfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	if h, err := ReadCarV2Header(io.NewSectionReader(f, 0, finfo.Size())); err == nil {
+		r = io.NewSectionReader(f, int64(h.DataOffset), int64(h.DataSize))
+	}
+	report, err := VerifyCar(r)
+	if err != nil {
+		return nil, xerrors.Errorf("verify %s: %w", path, err)
+	}
+	return report, nil
+}
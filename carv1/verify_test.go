@@ -0,0 +1,155 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+// rawCar writes a car v1 stream of roots holding the sections of nodes as given.
+func rawCar(t *testing.T, roots []cid.Cid, nodes ...format.Node) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range nodes {
+		if err := carutil.LdWrite(&buf, nd.Cid().Bytes(), nd.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func verify(t *testing.T, car []byte) *VerifyReport {
+	t.Helper()
+	report, err := VerifyCar(bytes.NewReader(car))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestVerifyCar(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	var car bytes.Buffer
+	size, err := NewBatch(context.Background(), dag).Write(tt.root.Cid(), &car, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := verify(t, car.Bytes())
+	if !report.OK() || report.Blocks != len(tt.nodes) || report.Size != size || report.PaddingSize != 0 {
+		t.Fatalf("%+v", report)
+	}
+	padded := bytes.NewBuffer(append([]byte{}, car.Bytes()...))
+	if err := PadCar(padded, int64(size)); err != nil {
+		t.Fatal(err)
+	}
+	report = verify(t, padded.Bytes())
+	if !report.OK() || report.Size != size || report.PaddingSize != uint64(padded.Len())-size {
+		t.Fatalf("padded: %+v", report)
+	}
+}
+
+func TestVerifyCarTruncated(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	car := rawCar(t, []cid.Cid{tt.root.Cid()}, tt.root, tt.nodes["a"], tt.nodes["b"])
+	for _, n := range []int{len(car) - 1, len(car) - len(tt.nodes["b"].RawData())} {
+		if _, err := VerifyCar(bytes.NewReader(car[:n])); err == nil {
+			t.Fatalf("verified a car cut at %d of %d bytes", n, len(car))
+		}
+	}
+}
+
+func TestVerifyCarHashMismatch(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	var car bytes.Buffer
+	if _, err := NewBatch(context.Background(), dag).Write(tt.root.Cid(), &car, 1); err != nil {
+		t.Fatal(err)
+	}
+	// the last block is the raw leaf e
+	corrupt := append([]byte{}, car.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	report := verify(t, corrupt)
+	if report.OK() || len(report.BadHashes) != 1 || !report.BadHashes[0].Cid.Equals(tt.nodes["e"].Cid()) {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestVerifyCarDuplicate(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	n := tt.nodes
+	car := rawCar(t, []cid.Cid{tt.root.Cid()}, tt.root, n["a"], n["c"], n["f"], n["g"], n["d"], n["b"], n["f"], n["e"])
+	report := verify(t, car)
+	if report.OK() || report.Blocks != 9 || len(report.Duplicates) != 1 || !report.Duplicates[0].Cid.Equals(n["f"].Cid()) {
+		t.Fatalf("%+v", report)
+	}
+	if len(report.BadHashes)+len(report.MissingLinks)+len(report.Orphans) != 0 {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestVerifyCarMissing(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	n := tt.nodes
+	orphan := merkledag.NewRawNode([]byte("orphan"))
+	car := rawCar(t, []cid.Cid{tt.root.Cid(), n["e"].Cid()}, tt.root, n["a"], n["c"], n["g"], n["d"], n["b"], orphan)
+	report := verify(t, car)
+	// e is both a missing root and a missing link of b
+	want := []MissingLink{{Parent: n["c"].Cid(), Link: n["f"].Cid()}, {Parent: n["b"].Cid(), Link: n["e"].Cid()}}
+	if len(report.MissingLinks) != len(want) {
+		t.Fatalf("missing links %+v", report.MissingLinks)
+	}
+	for i, ml := range report.MissingLinks {
+		if !ml.Parent.Equals(want[i].Parent) || !ml.Link.Equals(want[i].Link) {
+			t.Fatalf("missing links %+v", report.MissingLinks)
+		}
+	}
+	if len(report.MissingRoots) != 1 || !report.MissingRoots[0].Equals(n["e"].Cid()) {
+		t.Fatalf("missing roots %v", report.MissingRoots)
+	}
+	if len(report.Orphans) != 1 || !report.Orphans[0].Equals(orphan.Cid()) {
+		t.Fatalf("orphans %v", report.Orphans)
+	}
+}
+
+// TestVerifyCarIdentity checks that identity cids, whose data is in the cid,
+// are present as roots and links without being stored.
+func TestVerifyCarIdentity(t *testing.T) {
+	leaf := merkledag.NewRawNode([]byte("leaf"))
+	idLeaf, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}.Sum([]byte("inline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := merkledag.NodeWithData([]byte("inline root"))
+	nd.AddRawLink("leaf", &format.Link{Cid: leaf.Cid()})
+	nd.AddRawLink("inline", &format.Link{Cid: idLeaf})
+	nd.SetCidBuilder(cid.V1Builder{Codec: cid.DagProtobuf, MhType: multihash.IDENTITY})
+	if nd.Cid().Prefix().MhType != multihash.IDENTITY {
+		t.Fatal("root is not an identity cid")
+	}
+	// the root is inline, the leaf is reached through it
+	report := verify(t, rawCar(t, []cid.Cid{nd.Cid()}, leaf))
+	if !report.OK() {
+		t.Fatalf("%+v", report)
+	}
+	// an identity link needs no stored block
+	parent := merkledag.NodeWithData([]byte("parent"))
+	parent.AddRawLink("inline", &format.Link{Cid: idLeaf})
+	report = verify(t, rawCar(t, []cid.Cid{parent.Cid()}, parent))
+	if !report.OK() {
+		t.Fatalf("%+v", report)
+	}
+}
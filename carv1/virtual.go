@@ -0,0 +1,201 @@
+package carv1
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/filecoin-project/go-padreader"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"golang.org/x/xerrors"
+)
+
+// BlockGetter is what a VirtualCar reads blocks from, a blockstore.Blockstore is one.
+type BlockGetter interface {
+	Get(c cid.Cid) (blocks.Block, error)
+}
+
+// VirtualCar is the car file described by a Carv1Ref, generated on demand
+// from the blocks of a BlockGetter: any byte range can be read without the
+// car file ever being written. With padding the car is followed by the zero
+// bytes PadCar would add. ReadAt is safe for concurrent use, Read and Seek
+// share an offset like a file.
+type VirtualCar struct {
+	ref    *Carv1Ref
+	bs     BlockGetter
+	header []byte
+	size   int64
+
+	lk sync.Mutex
+	// the frame of the last block read, reads are often sequential
+	frameIdx int
+	frame    []byte
+
+	offset int64
+}
+
+// NewVirtualCar checks the header of ref against its roots, blocks are only
+// read from bs when their bytes are.
+func NewVirtualCar(ref *Carv1Ref, bs BlockGetter, padding bool) (*VirtualCar, error) {
+	if len(ref.DataRef) == 0 || ref.DataRef[0].Type != RefHeader {
+		return nil, xerrors.New("car ref has no header")
+	}
+	roots := make([]cid.Cid, 0, len(ref.DataRef[0].Blocks))
+	for _, s := range ref.DataRef[0].Blocks {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, c)
+	}
+	var header bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, &header); err != nil {
+		return nil, err
+	}
+	if uint64(header.Len()) != ref.DataRef[0].Size {
+		return nil, xerrors.Errorf("car header is %d bytes, ref says %d", header.Len(), ref.DataRef[0].Size)
+	}
+	// ReadAt expects the sections to follow each other
+	next := ref.DataRef[0].Size
+	for _, dr := range ref.DataRef[1:] {
+		if dr.Offset != next {
+			return nil, xerrors.Errorf("ref of %s at %d, the previous one ends at %d", dr.Block, dr.Offset, next)
+		}
+		next += dr.Size
+	}
+	if next != ref.Size {
+		return nil, xerrors.Errorf("car ref size is %d, its blocks end at %d", ref.Size, next)
+	}
+	size := int64(ref.Size)
+	if padding {
+		size = int64(padreader.PaddedSize(ref.Size))
+	}
+	return &VirtualCar{
+		ref:      ref,
+		bs:       bs,
+		header:   header.Bytes(),
+		size:     size,
+		frameIdx: -1,
+	}, nil
+}
+
+// Size of the car, padding included.
+func (vc *VirtualCar) Size() int64 {
+	return vc.size
+}
+
+func (vc *VirtualCar) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset: %d", off)
+	}
+	if off >= vc.size {
+		return 0, io.EOF
+	}
+	n := 0
+	refs := vc.ref.DataRef
+	// first ref holding off
+	i := sort.Search(len(refs), func(i int) bool {
+		return refs[i].Offset+refs[i].Size > uint64(off)
+	})
+	for n < len(p) && off < vc.size {
+		if i >= len(refs) {
+			// padding
+			m := int64(len(p) - n)
+			if m > vc.size-off {
+				m = vc.size - off
+			}
+			for j := int64(0); j < m; j++ {
+				p[n+int(j)] = 0
+			}
+			n += int(m)
+			off += m
+			break
+		}
+		frame, err := vc.frameAt(i)
+		if err != nil {
+			return n, err
+		}
+		m := copy(p[n:], frame[uint64(off)-refs[i].Offset:])
+		n += m
+		off += int64(m)
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// frameAt returns the bytes of the i-th ref of the car. The block is
+// fetched without holding the lock, concurrent reads of the same frame
+// may both fetch it.
+func (vc *VirtualCar) frameAt(i int) ([]byte, error) {
+	dr := vc.ref.DataRef[i]
+	if dr.Type == RefHeader {
+		return vc.header, nil
+	}
+	vc.lk.Lock()
+	if vc.frameIdx == i {
+		frame := vc.frame
+		vc.lk.Unlock()
+		return frame, nil
+	}
+	vc.lk.Unlock()
+	c, err := cid.Decode(dr.Block)
+	if err != nil {
+		return nil, err
+	}
+	blk, err := vc.bs.Get(c)
+	if err != nil {
+		return nil, xerrors.Errorf("get block %s: %w", c, err)
+	}
+	var frame bytes.Buffer
+	frame.Grow(int(dr.Size))
+	if err := carutil.LdWrite(&frame, c.Bytes(), blk.RawData()); err != nil {
+		return nil, err
+	}
+	if uint64(frame.Len()) != dr.Size {
+		return nil, xerrors.Errorf("block %s makes a frame of %d bytes, ref says %d", c, frame.Len(), dr.Size)
+	}
+	vc.lk.Lock()
+	vc.frameIdx, vc.frame = i, frame.Bytes()
+	vc.lk.Unlock()
+	return frame.Bytes(), nil
+}
+
+func (vc *VirtualCar) Read(p []byte) (int, error) {
+	vc.lk.Lock()
+	off := vc.offset
+	vc.lk.Unlock()
+	n, err := vc.ReadAt(p, off)
+	vc.lk.Lock()
+	vc.offset = off + int64(n)
+	vc.lk.Unlock()
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (vc *VirtualCar) Seek(offset int64, whence int) (int64, error) {
+	vc.lk.Lock()
+	defer vc.lk.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += vc.offset
+	case io.SeekEnd:
+		offset += vc.size
+	default:
+		return 0, xerrors.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, xerrors.Errorf("negative position: %d", offset)
+	}
+	vc.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,148 @@
+package carv1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// newStoreDAG is newDAG also returning the blockstore under the dag.
+func newStoreDAG() (format.DAGService, blockstore.Blockstore) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	return merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))), bs
+}
+
+func TestVirtualCar(t *testing.T) {
+	ctx := context.Background()
+	dag, bs := newStoreDAG()
+	root := buildFile(t, dag, randZeroData(2, 2))
+	b := NewBatch(ctx, dag)
+	var want bytes.Buffer
+	size, err := b.Write(root.Cid(), &want, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := b.Ref(root.Cid(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded := bytes.NewBuffer(append([]byte{}, want.Bytes()...))
+	if err := PadCar(padded, int64(size)); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(padded.Len()) == size {
+		t.Fatal("the car needs no padding")
+	}
+	for _, tc := range []struct {
+		padding bool
+		want    []byte
+	}{
+		{false, want.Bytes()},
+		{true, padded.Bytes()},
+	} {
+		vc, err := NewVirtualCar(ref, bs, tc.padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vc.Size() != int64(len(tc.want)) {
+			t.Fatalf("padding %t: size %d, expected %d", tc.padding, vc.Size(), len(tc.want))
+		}
+		got, err := io.ReadAll(vc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Fatalf("padding %t: virtual car differs from Write", tc.padding)
+		}
+		for i := 0; i < 100; i++ {
+			off := rand.Int63n(vc.Size())
+			buf := make([]byte, rand.Intn(3<<20))
+			n, err := vc.ReadAt(buf, off)
+			if err != nil && (err != io.EOF || off+int64(n) != vc.Size()) {
+				t.Fatalf("padding %t: read of %d bytes at %d: %s", tc.padding, len(buf), off, err)
+			}
+			if !bytes.Equal(buf[:n], tc.want[off:off+int64(n)]) {
+				t.Fatalf("padding %t: %d bytes at %d differ from Write", tc.padding, len(buf), off)
+			}
+		}
+	}
+}
+
+// blockingGetter blocks Get of c until release is closed.
+type blockingGetter struct {
+	BlockGetter
+	c       cid.Cid
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bg *blockingGetter) Get(c cid.Cid) (blocks.Block, error) {
+	if c.Equals(bg.c) {
+		close(bg.started)
+		<-bg.release
+	}
+	return bg.BlockGetter.Get(c)
+}
+
+// TestVirtualCarSlowGet reads a cached frame while the block of another one is being fetched.
+func TestVirtualCarSlowGet(t *testing.T) {
+	ctx := context.Background()
+	dag, bs := newStoreDAG()
+	tt := buildTestTree(t, dag)
+	ref, err := NewBatch(ctx, dag).Ref(tt.root.Cid(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := cid.Decode(ref.DataRef[2].Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bg := &blockingGetter{BlockGetter: bs, c: slow, started: make(chan struct{}), release: make(chan struct{})}
+	vc, err := NewVirtualCar(ref, bg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, ref.DataRef[1].Size)
+	if _, err := vc.ReadAt(first, int64(ref.DataRef[1].Offset)); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, ref.DataRef[2].Size)
+		if _, err := vc.ReadAt(buf, int64(ref.DataRef[2].Offset)); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-bg.started
+	done := make(chan error, 1)
+	go func() {
+		_, err := vc.ReadAt(make([]byte, len(first)), int64(ref.DataRef[1].Offset))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read of a cached frame waits for the fetch of another block")
+	}
+	close(bg.release)
+	wg.Wait()
+}
@@ -0,0 +1,170 @@
+package carv1
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// Order is the order in which the blocks of a dag are written to a car file.
+// Every block is written once, at its first position in the order.
+type Order int
+
+const (
+	// OrderDFS is the depth first pre-order of go-car's WriteCar: a block,
+	// then the whole subtree of its first link, then of the next one. Car
+	// files are byte-for-byte the ones go-car and lotus make for the same root.
+	// It is the default, and the layout Write had before orders could be set.
+	OrderDFS Order = iota
+	// OrderBFS writes the dag level by level, each level in link order.
+	OrderBFS
+)
+
+func (o Order) String() string {
+	switch o {
+	case OrderDFS:
+		return "dfs"
+	case OrderBFS:
+		return "bfs"
+	default:
+		return "unknown"
+	}
+}
+
+type BatchOption func(*BatchBuilder)
+
+// WithOrder sets the order of the blocks for Write and Ref, OrderDFS if not set.
+func WithOrder(order Order) BatchOption {
+	return func(b *BatchBuilder) {
+		b.order = order
+	}
+}
+
+// walk calls cb once for every unique block of the dag of nd, nd included,
+// in the order of the builder. seen is shared by the roots of a car file.
+// A batchNum below 1 fetches one block at a time.
+func (b *BatchBuilder) walk(nd format.Node, batchNum int, seen *cid.Set, cb func(nd format.Node) error) error {
+	if batchNum < 1 {
+		batchNum = 1
+	}
+	switch b.order {
+	case OrderDFS:
+		if !seen.Visit(nd.Cid()) {
+			return nil
+		}
+		if err := cb(nd); err != nil {
+			return err
+		}
+		return b.walkDFS(nd, batchNum, seen, cb)
+	case OrderBFS:
+		return b.walkBFS(nd, batchNum, seen, cb)
+	default:
+		return xerrors.Errorf("unknown order: %d", b.order)
+	}
+}
+
+// walkDFS visits the children of nd depth first, skipping the subtrees
+// of blocks already seen like merkledag.Walk does. Children are fetched
+// batchNum at a time.
+func (b *BatchBuilder) walkDFS(nd format.Node, batchNum int, seen *cid.Set, cb func(nd format.Node) error) error {
+	links := nd.Links()
+	for start := 0; start < len(links); start += batchNum {
+		end := start + batchNum
+		if end > len(links) {
+			end = len(links)
+		}
+		cids := make([]cid.Cid, 0, end-start)
+		for _, l := range links[start:end] {
+			cids = append(cids, l.Cid)
+		}
+		nodes, err := getNodes(b.ctx, b.bs, cids, batchNum)
+		if err != nil {
+			return err
+		}
+		for _, child := range nodes {
+			if !seen.Visit(child.Cid()) {
+				continue
+			}
+			if err := cb(child); err != nil {
+				return err
+			}
+			if err := b.walkDFS(child, batchNum, seen, cb); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkBFS visits the dag of nd level by level, only the cids of
+// the blocks waiting to be visited are kept in memory.
+func (b *BatchBuilder) walkBFS(nd format.Node, batchNum int, seen *cid.Set, cb func(nd format.Node) error) error {
+	if !seen.Visit(nd.Cid()) {
+		return nil
+	}
+	queue := []cid.Cid{nd.Cid()}
+	nodes := []format.Node{nd}
+	for len(queue) > 0 {
+		if nodes == nil {
+			n := batchNum
+			if n > len(queue) {
+				n = len(queue)
+			}
+			var err error
+			if nodes, err = getNodes(b.ctx, b.bs, queue[:n], batchNum); err != nil {
+				return err
+			}
+		}
+		queue = queue[len(nodes):]
+		for _, node := range nodes {
+			if err := cb(node); err != nil {
+				return err
+			}
+			for _, l := range node.Links() {
+				if seen.Visit(l.Cid) {
+					queue = append(queue, l.Cid)
+				}
+			}
+		}
+		nodes = nil
+	}
+	return nil
+}
+
+// getNodes fetches cids with up to batchNum concurrent requests,
+// retrying each failed request once.
+func getNodes(ctx context.Context, ng format.NodeGetter, cids []cid.Cid, batchNum int) ([]format.Node, error) {
+	if batchNum < 1 {
+		batchNum = 1
+	}
+	nodes := make([]format.Node, len(cids))
+	errs := make([]error, len(cids))
+	var wg sync.WaitGroup
+	batchchan := make(chan struct{}, batchNum)
+	wg.Add(len(cids))
+	for i, c := range cids {
+		batchchan <- struct{}{}
+		go func(i int, c cid.Cid) {
+			defer func() {
+				<-batchchan
+				wg.Done()
+			}()
+			nd, err := ng.Get(ctx, c)
+			if err != nil {
+				// try get one more time
+				nd, err = ng.Get(ctx, c)
+			}
+			nodes[i], errs[i] = nd, err
+		}(i, c)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, xerrors.Errorf("get %s: %w", cids[i], err)
+		}
+	}
+	return nodes, nil
+}
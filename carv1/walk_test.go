@@ -0,0 +1,155 @@
+package carv1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// testTree is a dag of three levels whose subtree c is linked twice:
+//
+//	root -> a, b
+//	a    -> c, d
+//	b    -> c, e
+//	c    -> f, g
+//
+// d, e, f and g are raw leaves.
+type testTree struct {
+	root  format.Node
+	nodes map[string]format.Node
+}
+
+func buildTestTree(t *testing.T, dag format.DAGService) *testTree {
+	t.Helper()
+	tt := &testTree{nodes: make(map[string]format.Node)}
+	for _, name := range []string{"d", "e", "f", "g"} {
+		tt.nodes[name] = merkledag.NewRawNode([]byte("leaf " + name))
+	}
+	for _, p := range []struct {
+		name  string
+		links []string
+	}{
+		{"c", []string{"f", "g"}},
+		{"a", []string{"c", "d"}},
+		{"b", []string{"c", "e"}},
+		{"root", []string{"a", "b"}},
+	} {
+		nd := merkledag.NodeWithData([]byte("node " + p.name))
+		nd.SetCidBuilder(merkledag.V1CidPrefix())
+		for _, l := range p.links {
+			if err := nd.AddNodeLink(l, tt.nodes[l]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tt.nodes[p.name] = nd
+	}
+	for _, nd := range tt.nodes {
+		if err := dag.Add(context.Background(), nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tt.root = tt.nodes["root"]
+	return tt
+}
+
+func (tt *testTree) names(t *testing.T, cids []cid.Cid) []string {
+	t.Helper()
+	byCid := make(map[cid.Cid]string)
+	for name, nd := range tt.nodes {
+		byCid[nd.Cid()] = name
+	}
+	res := make([]string, 0, len(cids))
+	for _, c := range cids {
+		name, ok := byCid[c]
+		if !ok {
+			t.Fatalf("unknown block %s", c)
+		}
+		res = append(res, name)
+	}
+	return res
+}
+
+func walkCids(t *testing.T, b *BatchBuilder, root format.Node, batchNum int) []cid.Cid {
+	t.Helper()
+	var res []cid.Cid
+	if err := b.walk(root, batchNum, cid.NewSet(), func(nd format.Node) error {
+		res = append(res, nd.Cid())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestWalkOrder(t *testing.T) {
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	for _, tc := range []struct {
+		order Order
+		want  []string
+	}{
+		{OrderDFS, []string{"root", "a", "c", "f", "g", "d", "b", "e"}},
+		{OrderBFS, []string{"root", "a", "b", "c", "d", "e", "f", "g"}},
+	} {
+		for _, batchNum := range []int{0, 1, 2, 8} {
+			got := tt.names(t, walkCids(t, NewBatch(context.Background(), dag, WithOrder(tc.order)), tt.root, batchNum))
+			if len(got) != len(tc.want) {
+				t.Fatalf("%s, batch %d: %v", tc.order, batchNum, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("%s, batch %d: %v, expected %v", tc.order, batchNum, got, tc.want)
+				}
+			}
+		}
+	}
+}
+
+// TestBlockWalkOrder checks that OrderDFS keeps the layout Write had when
+// it wrote the root then the first visit of every block of BlockWalk.
+func TestBlockWalkOrder(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	seen := cid.NewSet()
+	seen.Add(tt.root.Cid())
+	old := []cid.Cid{tt.root.Cid()}
+	visits := 0
+	if err := BlockWalk(ctx, tt.root, dag, 2, func(nd format.Node) error {
+		visits++
+		if seen.Visit(nd.Cid()) {
+			old = append(old, nd.Cid())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// c, f and g are visited once per path
+	if visits != 10 {
+		t.Fatalf("%d visits", visits)
+	}
+	dfs := walkCids(t, NewBatch(ctx, dag), tt.root, 2)
+	if len(old) != len(dfs) {
+		t.Fatalf("BlockWalk %v, OrderDFS %v", tt.names(t, old), tt.names(t, dfs))
+	}
+	for i := range old {
+		if !old[i].Equals(dfs[i]) {
+			t.Fatalf("BlockWalk %v, OrderDFS %v", tt.names(t, old), tt.names(t, dfs))
+		}
+	}
+}
+
+func TestBlockWalkMissing(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+	tt := buildTestTree(t, dag)
+	if err := dag.Remove(ctx, tt.nodes["e"].Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := BlockWalk(ctx, tt.root, dag, 4, func(format.Node) error { return nil }); err == nil {
+		t.Fatal("walked a dag with a missing block")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filedrive-team/filehelper/carv1"
+	"golang.org/x/xerrors"
+)
+
+var inspectCmd = &command{
+	name:  "inspect",
+	usage: "inspect [-json] [-top n] <car file>",
+	run:   runInspect,
+}
+
+func runInspect(args []string) error {
+	return inspect(os.Stdout, args)
+}
+
+func inspect(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as json")
+	top := fs.Int("top", 10, "number of largest blocks to list")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return xerrors.New("expected one car file")
+	}
+	report, err := carv1.InspectCarFile(fs.Arg(0), *top)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	printInspect(w, report)
+	return nil
+}
+
+func printInspect(w io.Writer, r *carv1.InspectReport) {
+	fmt.Fprintf(w, "version: %d\n", r.Version)
+	if r.V2 != nil {
+		fmt.Fprintf(w, "data offset: %d, data size: %d, index offset: %d\n", r.V2.DataOffset, r.V2.DataSize, r.V2.IndexOffset)
+	}
+	fmt.Fprintf(w, "roots:\n")
+	for _, root := range r.Roots {
+		fmt.Fprintf(w, "  %s\n", root)
+	}
+	fmt.Fprintf(w, "header size: %d\n", r.HeaderSize)
+	fmt.Fprintf(w, "size: %d, padding: %d\n", r.Size, r.PaddingSize)
+	fmt.Fprintf(w, "blocks: %d, unique: %d, duplicates: %d\n", r.Blocks, r.UniqueBlocks, len(r.Duplicates))
+	fmt.Fprintf(w, "codecs:\n")
+	for _, c := range r.Codecs {
+		fmt.Fprintf(w, "  %-12s %8d blocks %14d bytes\n", c.Codec, c.Blocks, c.Bytes)
+	}
+	fmt.Fprintf(w, "dags:\n")
+	for _, d := range r.Dags {
+		fmt.Fprintf(w, "  %s: depth %d, %d blocks, %d bytes, fanout max %d avg %.1f, missing %d\n",
+			d.Root, d.Depth, d.Blocks, d.Bytes, d.MaxFanout, d.AvgFanout, d.MissingBlocks)
+		if d.Cycles > 0 {
+			fmt.Fprintf(w, "    %d links closing a cycle, the blocks do not match their cids\n", d.Cycles)
+		}
+	}
+	fmt.Fprintf(w, "largest blocks:\n")
+	for _, b := range r.Largest {
+		fmt.Fprintf(w, "  %s %d bytes at %d\n", b.Cid, b.Size, b.Offset)
+	}
+	if len(r.Duplicates) > 0 {
+		fmt.Fprintf(w, "duplicate blocks:\n")
+		for _, b := range r.Duplicates {
+			fmt.Fprintf(w, "  %s %d bytes at %d\n", b.Cid, b.Size, b.Offset)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/ipfs/go-blockservice"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// writeCar writes a car of a root over two raw leaves to dir and returns its path and root.
+func writeCar(t *testing.T, dir string) (string, format.Node) {
+	t.Helper()
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	root := merkledag.NodeWithData([]byte("root"))
+	for _, data := range []string{"first leaf", "second leaf"} {
+		leaf := merkledag.NewRawNode([]byte(data))
+		if err := dag.Add(ctx, leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(data, leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dag.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.car")
+	if err := carv1.NewBatch(ctx, dag).WriteToFile(root.Cid(), path, 1); err != nil {
+		t.Fatal(err)
+	}
+	return path, root
+}
+
+func TestInspect(t *testing.T) {
+	path, root := writeCar(t, t.TempDir())
+	var out bytes.Buffer
+	if err := inspect(&out, []string{"-top", "1", path}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"version: 1\n",
+		"  " + root.Cid().String() + "\n",
+		"blocks: 3, unique: 3, duplicates: 0\n",
+		"  " + root.Cid().String() + ": depth 2, 3 blocks,",
+		"largest blocks:\n  ",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Fatalf("%q not in\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "duplicate blocks:") {
+		t.Fatalf("duplicates listed:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := inspect(&out, []string{"-json", path}); err != nil {
+		t.Fatal(err)
+	}
+	var report carv1.InspectReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Roots) != 1 || !report.Roots[0].Equals(root.Cid()) || report.UniqueBlocks != 3 || len(report.Largest) != 3 {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := inspect(&bytes.Buffer{}, []string{filepath.Join(dir, "missing.car")}); err == nil {
+		t.Fatal("inspected a missing file")
+	}
+	path, _ := writeCar(t, dir)
+	if err := inspect(&bytes.Buffer{}, []string{path, path}); err == nil {
+		t.Fatal("inspected two files")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/filedrive-team/filehelper/carserver"
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/filedrive-team/filehelper/commp"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+var serveCmd = &command{
+	name:  "serve",
+	usage: "serve [-addr host:port] <car file>...",
+	run:   runServe,
+}
+
+// runServe serves each car file, without its padding, by payload cid and
+// padded by piece cid, generated from its ref and blocks.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return xerrors.New("expected car files")
+	}
+	srv := carserver.New()
+	for _, path := range fs.Args() {
+		ref, err := carv1.RefFromCarFile(path)
+		if err != nil {
+			return err
+		}
+		ng, err := carv1.OpenCarNodeGetter(path)
+		if err != nil {
+			return xerrors.Errorf("open %s: %w", path, err)
+		}
+		defer ng.Close()
+		bs := carBlocks{ng}
+		vc, err := carv1.NewVirtualCar(ref, bs, false)
+		if err != nil {
+			return err
+		}
+		cp := commp.NewCalc()
+		if _, err := io.Copy(cp, vc); err != nil {
+			return xerrors.Errorf("commp %s: %w", path, err)
+		}
+		pieceCid, pieceSize, err := cp.Sum()
+		if err != nil {
+			return err
+		}
+		if err := srv.Add(ref, bs, pieceCid); err != nil {
+			return err
+		}
+		fmt.Printf("%s: payload %s, piece %s, car size %d, piece size %d\n", path, ng.Roots()[0], pieceCid, ref.Size, pieceSize)
+	}
+	fmt.Printf("listening on %s\n", *addr)
+	return http.ListenAndServe(*addr, srv)
+}
+
+// carBlocks reads the blocks of a VirtualCar from a car file.
+type carBlocks struct {
+	ng *carv1.CarNodeGetter
+}
+
+func (cb carBlocks) Get(c cid.Cid) (blocks.Block, error) {
+	return cb.ng.GetBlock(c)
+}
@@ -0,0 +1,220 @@
+// Package commp computes the Filecoin piece commitment (CommP) of a payload.
+package commp
+
+import (
+	"crypto/sha256"
+	"math/bits"
+
+	"github.com/filecoin-project/go-padreader"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+)
+
+// multicodec of unsealed piece cids
+const FilCommitmentUnsealed = 0xf101
+
+// MinPayloadSize is the smallest payload with a piece commitment, as in
+// go-fil-commp-hashhash. Payloads of up to 127 bytes are zero padded to 127
+// bytes before fr32 padding makes them the 128 bytes of the smallest piece.
+const MinPayloadSize = 65
+
+// fr32 padding turns every 127 bytes of payload into 4 merkle leaves of 32 bytes
+const (
+	unpaddedChunk = 127
+	paddedChunk   = 128
+	nodeSize      = 32
+)
+
+// zeroHashes[i] is the root of a subtree of 2^i zero leaves
+var zeroHashes = func() [][]byte {
+	hashes := make([][]byte, 64)
+	hashes[0] = make([]byte, nodeSize)
+	for i := 1; i < len(hashes); i++ {
+		hashes[i] = hashNodes(hashes[i-1], hashes[i-1])
+	}
+	return hashes
+}()
+
+// Calc is an io.Writer computing the piece commitment of the bytes written
+// to it. The payload is zero padded up to the unpadded size of the smallest
+// piece holding it, as padreader and PadCar do, so the result is the same
+// whether the padding is written or not.
+type Calc struct {
+	// pending payload bytes, less than a chunk
+	buf []byte
+	n   uint64
+	// layers[i] is the left node at level i waiting for its right sibling
+	layers [][]byte
+	chunk  [paddedChunk]byte
+}
+
+func NewCalc() *Calc {
+	return &Calc{
+		buf: make([]byte, 0, unpaddedChunk),
+	}
+}
+
+func (c *Calc) Write(p []byte) (int, error) {
+	written := len(p)
+	c.n += uint64(written)
+	if len(c.buf) > 0 {
+		m := copy(c.buf[len(c.buf):unpaddedChunk], p)
+		c.buf = c.buf[:len(c.buf)+m]
+		p = p[m:]
+		if len(c.buf) < unpaddedChunk {
+			return written, nil
+		}
+		c.addChunk(c.buf)
+		c.buf = c.buf[:0]
+	}
+	for len(p) >= unpaddedChunk {
+		c.addChunk(p[:unpaddedChunk])
+		p = p[unpaddedChunk:]
+	}
+	c.buf = append(c.buf, p...)
+	return written, nil
+}
+
+// PayloadSize is the number of bytes written so far.
+func (c *Calc) PayloadSize() uint64 {
+	return c.n
+}
+
+// Sum returns the piece cid and the padded piece size of the bytes written
+// so far, more bytes can still be written afterwards.
+func (c *Calc) Sum() (cid.Cid, uint64, error) {
+	if c.n < MinPayloadSize {
+		return cid.Undef, 0, xerrors.Errorf("commp is not defined for payloads shorter than %d bytes, got %d", MinPayloadSize, c.n)
+	}
+	pieceSize := uint64(padreader.PaddedSize(c.n).Padded())
+	depth := bits.TrailingZeros64(pieceSize / nodeSize)
+
+	layers := make([][]byte, len(c.layers))
+	copy(layers, c.layers)
+	if len(c.buf) > 0 {
+		chunk := make([]byte, unpaddedChunk)
+		copy(chunk, c.buf)
+		layers = c.pushChunk(layers, chunk)
+	}
+	if len(layers) > depth+1 || (len(layers) == depth+1 && layers[depth] == nil) {
+		return cid.Undef, 0, xerrors.New("merkle tree deeper than the piece")
+	}
+	var root []byte
+	if len(layers) == depth+1 {
+		// the payload fills the piece exactly
+		root = layers[depth]
+	} else {
+		// the rest of the piece is zero leaves, carry the pending
+		// left nodes up with zero subtrees on their right
+		var carry []byte
+		for i := 0; i < depth; i++ {
+			var left []byte
+			if i < len(layers) {
+				left = layers[i]
+			}
+			switch {
+			case left != nil && carry != nil:
+				carry = hashNodes(left, carry)
+			case left != nil:
+				carry = hashNodes(left, zeroHashes[i])
+			case carry != nil:
+				carry = hashNodes(carry, zeroHashes[i])
+			}
+		}
+		root = carry
+	}
+	pieceCid, err := PieceCid(root)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return pieceCid, pieceSize, nil
+}
+
+// PieceCid is the unsealed piece cid of a CommP merkle root.
+func PieceCid(commP []byte) (cid.Cid, error) {
+	if len(commP) != nodeSize {
+		return cid.Undef, xerrors.Errorf("invalid commitment length: %d", len(commP))
+	}
+	mh, err := multihash.Encode(commP, multihash.SHA2_256_TRUNC254_PADDED)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(FilCommitmentUnsealed, mh), nil
+}
+
+func (c *Calc) addChunk(in []byte) {
+	c.layers = c.pushChunk(c.layers, in)
+}
+
+// pushChunk fr32 pads 127 bytes of payload and adds the 4 leaves to layers.
+func (c *Calc) pushChunk(layers [][]byte, in []byte) [][]byte {
+	out := c.chunk[:]
+	fr32Pad(in, out)
+	for i := 0; i < paddedChunk; i += nodeSize {
+		leaf := make([]byte, nodeSize)
+		copy(leaf, out[i:i+nodeSize])
+		layers = pushNode(layers, leaf, 0)
+	}
+	return layers
+}
+
+func pushNode(layers [][]byte, node []byte, level int) [][]byte {
+	for {
+		if level == len(layers) {
+			layers = append(layers, nil)
+		}
+		if layers[level] == nil {
+			layers[level] = node
+			return layers
+		}
+		node = hashNodes(layers[level], node)
+		layers[level] = nil
+		level++
+	}
+}
+
+// hashNodes is the sha256-trunc254 hash of two nodes.
+func hashNodes(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	out := h.Sum(nil)
+	out[nodeSize-1] &= 0x3f
+	return out
+}
+
+// fr32Pad spreads 127 bytes into 128, leaving the two high bits of every 32 bytes to 0.
+func fr32Pad(in, out []byte) {
+	copy(out[:31], in[:31])
+
+	t := in[31] >> 6
+	out[31] = in[31] & 0x3f
+	var v byte
+
+	for i := 32; i < 64; i++ {
+		v = in[i]
+		out[i] = (v << 2) | t
+		t = v >> 6
+	}
+
+	t = v >> 4
+	out[63] &= 0x3f
+
+	for i := 64; i < 96; i++ {
+		v = in[i]
+		out[i] = (v << 4) | t
+		t = v >> 4
+	}
+
+	t = v >> 2
+	out[95] &= 0x3f
+
+	for i := 96; i < 127; i++ {
+		v = in[i]
+		out[i] = (v << 6) | t
+		t = v >> 2
+	}
+
+	out[127] = t & 0x3f
+}
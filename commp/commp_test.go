@@ -0,0 +1,92 @@
+package commp
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+// vectorData is the payload of the vectors computed with go-fil-commp-hashhash.
+func vectorData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	return data
+}
+
+func TestSumVectors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		pieceCid  string
+		pieceSize uint64
+	}{
+		{"65 zero", make([]byte, 65), "baga6ea4seaqdomn3tgwgrh3g532zopskstnbrd2n3sxfqbze7rxt7vqn7veigmy", 128},
+		{"127 zero", make([]byte, 127), "baga6ea4seaqdomn3tgwgrh3g532zopskstnbrd2n3sxfqbze7rxt7vqn7veigmy", 128},
+		{"128 zero", make([]byte, 128), "baga6ea4seaqgiktap34inmaex4wbs6cghlq5i2j2yd2bb2zndn5ep7ralzphkdy", 256},
+		{"65", vectorData(65), "baga6ea4seaqe5uz3h4y4kw3npiwltveea3ecwhmz5m5bmdlbw522eilqjzehsly", 128},
+		{"127", vectorData(127), "baga6ea4seaqltsxiww5kpihvcdng4mdzzqmqnj7r3nzptei627uhdypsyish2li", 128},
+		{"128", vectorData(128), "baga6ea4seaqjwgolfttkhm25sxjoui2himludjfc4wxxtzkfa7ooeur4yd622jq", 256},
+		{"1000", vectorData(1000), "baga6ea4seaqgvvgebur3bdxyjl2s75oexlkez3flnmgysqwdptubcbrasofzwfa", 1024},
+		{"127KiB+1", vectorData(127<<10 + 1), "baga6ea4seaqdcepm3hpbrr4lsgkngmjpps5jeqpf7eathzuo7crgmbka2ohf4eq", 256 << 10},
+		{"1MiB", vectorData(1 << 20), "baga6ea4seaqixnfo57zznduppd56olerdpatc3g2gy3crxfn43yfwml4hwlicli", 2 << 20},
+	} {
+		c := NewCalc()
+		c.Write(tc.data)
+		pieceCid, pieceSize, err := c.Sum()
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if pieceCid.String() != tc.pieceCid || pieceSize != tc.pieceSize {
+			t.Fatalf("%s: %s of %d bytes, expected %s of %d", tc.name, pieceCid, pieceSize, tc.pieceCid, tc.pieceSize)
+		}
+		if c.PayloadSize() != uint64(len(tc.data)) {
+			t.Fatalf("%s: payload size %d", tc.name, c.PayloadSize())
+		}
+	}
+}
+
+func TestSumTooShort(t *testing.T) {
+	for _, n := range []int{0, 1, MinPayloadSize - 1} {
+		c := NewCalc()
+		c.Write(make([]byte, n))
+		if _, _, err := c.Sum(); err == nil {
+			t.Fatalf("commp of %d bytes", n)
+		}
+	}
+}
+
+// TestSumWrites checks that the commitment does not depend on how the
+// payload is split into writes, and that Sum can be called along the way.
+func TestSumWrites(t *testing.T) {
+	data := make([]byte, 3<<20+12345)
+	rand.Read(data)
+	whole := NewCalc()
+	whole.Write(data)
+	want, wantSize, err := whole.Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []int{1, 126, 127, 128, 4093, 1 << 20} {
+		c := NewCalc()
+		for off := 0; off < len(data); off += step {
+			end := off + step
+			if end > len(data) {
+				end = len(data)
+			}
+			c.Write(data[off:end])
+			if off == 0 && step >= MinPayloadSize {
+				if _, _, err := c.Sum(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		got, size, err := c.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(want) || size != wantSize {
+			t.Fatalf("writes of %d: %s of %d, expected %s of %d", step, got, size, want, wantSize)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package dataset
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/filedrive-team/filehelper/progress"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// ImportToCar imports the files of targets straight into a car v1 file at
+// carPath, without going through a blockstore. The root of every non empty
+// file is listed in the car header, in walk order, and the files are
+// returned in the same order. Blocks shared by several files are written
+// once. Blocks are written in import order, leaves before their parents, so
+// the car file and its piece cid differ from the ones BatchBuilder.Write
+// makes for the same roots, see carv1.CarWriter. obs may be nil, it also
+// receives the dataset totals if it implements progress.DatasetObserver.
+// The car file is removed if the import fails.
+func ImportToCar(ctx context.Context, cidBuilder cid.Prefix, parallel, batchReadNum int, carPath string, targets []string, obs progress.Observer) (_ []*MetaData, err error) {
+	items := make([]filehelper.Finfo, 0)
+	var totalSize uint64
+	for item := range filehelper.FileWalkAsync(targets) {
+		if item.Info.Size() == 0 {
+			continue
+		}
+		items = append(items, item)
+		totalSize += uint64(item.Info.Size())
+	}
+	if len(items) == 0 {
+		return nil, xerrors.New("no file to import")
+	}
+	cw, err := carv1.CreateCarWriter(carPath, len(items), cidBuilder)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			cw.Close()
+			os.Remove(carPath)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	roots := make([]cid.Cid, len(items))
+	var importedFiles, importedSize uint64
+	start := time.Now()
+	dobs, _ := obs.(progress.DatasetObserver)
+	var ferr error
+	var errOnce sync.Once
+	pchan := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	for i, item := range items {
+		wg.Add(1)
+		pchan <- struct{}{}
+		go func(i int, item filehelper.Finfo) {
+			defer func() {
+				<-pchan
+				wg.Done()
+			}()
+			if ctx.Err() != nil {
+				return
+			}
+			root, err := buildFileNode(ctx, item, cw, cidBuilder, batchReadNum, obs, "")
+			if err != nil {
+				errOnce.Do(func() {
+					ferr = xerrors.Errorf("import %s: %w", item.Path, err)
+					cancel()
+				})
+				return
+			}
+			roots[i] = root
+			files := atomic.AddUint64(&importedFiles, 1)
+			size := atomic.AddUint64(&importedSize, uint64(item.Info.Size()))
+			if dobs != nil {
+				dobs.DatasetProgress(progress.NewDatasetProgress(uint64(len(items)), totalSize, files, size, start))
+			}
+		}(i, item)
+	}
+	wg.Wait()
+	if ferr != nil {
+		return nil, ferr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	carSize, err := cw.Finish(roots)
+	if err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	log.Infof("car file %s: %d files, %d bytes", carPath, len(items), carSize)
+
+	res := make([]*MetaData, 0, len(items))
+	for i, item := range items {
+		res = append(res, &MetaData{
+			Path: item.Path,
+			Name: item.Name,
+			Size: item.Info.Size(),
+			CID:  roots[i].String(),
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package dataset_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/filedrive-team/filehelper/dataset"
+	"github.com/filedrive-team/filehelper/exporter"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+)
+
+func TestImportToCar(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+	files := writeFiles(t, src, map[string]int{
+		"large":  3<<20 + 5,
+		"medium": 1 << 20,
+		"small":  1000,
+	})
+	carPath := filepath.Join(t.TempDir(), "dataset.car")
+	metas, err := dataset.ImportToCar(ctx, merkledag.V1CidPrefix(), 2, 4, carPath, []string{src}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != len(files) {
+		t.Fatalf("%d files imported", len(metas))
+	}
+
+	f, err := os.Open(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := carv1.VerifyCar(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("%+v", report)
+	}
+	roots := make([]cid.Cid, 0, len(metas))
+	for i, meta := range metas {
+		root, err := cid.Decode(meta.CID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Roots[i].Equals(root) {
+			t.Fatalf("root %d is %s, %s imported", i, report.Roots[i], root)
+		}
+		roots = append(roots, root)
+	}
+
+	// through the blocks of the car file
+	ng, err := carv1.OpenCarNodeGetter(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ng.Close()
+	target := t.TempDir()
+	for i, meta := range metas {
+		if err := exporter.Export(ctx, ng, roots[i], target, exporter.Options{Name: meta.Name}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(target, meta.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, files[meta.Path]) {
+			t.Fatalf("%s differs", meta.Path)
+		}
+	}
+	// straight from the car file, the blocks come leaves first
+	target = t.TempDir()
+	if err := exporter.ExtractCarFile(ctx, carPath, target, exporter.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, meta := range metas {
+		got, err := os.ReadFile(filepath.Join(target, meta.CID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, files[meta.Path]) {
+			t.Fatalf("%s extracted differs", meta.Path)
+		}
+	}
+
+	// same blocks as BatchBuilder, in another layout
+	var car bytes.Buffer
+	if _, err := carv1.NewBatch(ctx, ng).WriteMulti(roots, &car, 2); err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := os.ReadFile(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed) != car.Len() || bytes.Equal(streamed, car.Bytes()) {
+		t.Fatalf("streamed car of %d bytes, BatchBuilder gives %d", len(streamed), car.Len())
+	}
+}
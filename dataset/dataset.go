@@ -11,9 +11,11 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/filedrive-team/filehelper"
 	"github.com/filedrive-team/filehelper/importer"
+	"github.com/filedrive-team/filehelper/progress"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
@@ -26,17 +28,60 @@ import (
 
 const record_json = "record.json"
 const record_csv = "record.csv"
+const chunk_index_dir = "chunk_index"
+const split_record_json = "split_record.json"
 
 type MetaData struct {
 	Path string `json:"path"`
 	Name string `json:"name"`
 	Size int64  `json:"size"`
 	CID  string `json:"cid"`
+	// Split is set for files imported as slices, CID is then empty
+	// and the slices are in split_record.json, see ReadSplitRecords
+	Split bool `json:"split,omitempty"`
 }
 
 var log = logging.Logger("filehelper/dataset")
 
-func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, parallel, batchReadNum int, prefix, recordDir string, targets []string) error {
+// Option configures the optional behaviours of Import and ImportWithProgress.
+type Option func(*importOptions)
+
+type importOptions struct {
+	chunkIndex bool
+	sliceSize  int64
+}
+
+// WithChunkIndex saves the chunk index of every imported file
+// in the chunk_index dir next to record.csv, see ReadChunkIndex.
+func WithChunkIndex() Option {
+	return func(o *importOptions) {
+		o.chunkIndex = true
+	}
+}
+
+// WithSplit imports the files larger than sliceSize as slices of sliceSize bytes,
+// see filehelper.BuildSplitFile. Their records are saved in split_record.json
+// next to record.json and not listed in record.csv.
+func WithSplit(sliceSize int64) Option {
+	return func(o *importOptions) {
+		o.sliceSize = sliceSize
+	}
+}
+
+// Import imports the files of targets into bs and records their root cids in recordDir.
+// It reports no progress, use ImportWithProgress with progress.NewPrinter(os.Stdout)
+// for the progress lines Import used to print.
+func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, parallel, batchReadNum int, prefix, recordDir string, targets []string, opts ...Option) error {
+	return ImportWithProgress(ctx, bs, cidBuilder, parallel, batchReadNum, prefix, recordDir, targets, nil, opts...)
+}
+
+// ImportWithProgress is Import reporting the progress of every file to obs, which may be nil,
+// obs also receives the dataset totals if it implements progress.DatasetObserver.
+func ImportWithProgress(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, parallel, batchReadNum int, prefix, recordDir string, targets []string, obs progress.Observer, opts ...Option) error {
+	o := &importOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
 	// checkout if record dir exists
 	rdinfo, err := os.Stat(recordDir)
 	if err != nil {
@@ -45,6 +90,13 @@ func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, pa
 	if !rdinfo.IsDir() {
 		return xerrors.New("record dir is not a dir!")
 	}
+	indexDir := ""
+	if o.chunkIndex {
+		indexDir = path.Join(recordDir, chunk_index_dir)
+		if err := os.MkdirAll(indexDir, 0755); err != nil {
+			return err
+		}
+	}
 
 	recordPath := path.Join(recordDir, record_json)
 	// check if record.json has data
@@ -52,6 +104,11 @@ func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, pa
 	if err != nil {
 		return err
 	}
+	splitPath := path.Join(recordDir, split_record_json)
+	splits, err := readSplitRecords(splitPath)
+	if err != nil {
+		return err
+	}
 	// set up a goroutine to receive csv record line by line
 	recordCSVPath := path.Join(recordDir, record_csv)
 	csvChan := make(chan string)
@@ -84,6 +141,8 @@ func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, pa
 	var total_files uint64 = 0
 	var total_size uint64
 	var importedSize uint64
+	start := time.Now()
+	dobs, _ := obs.(progress.DatasetObserver)
 	go func() {
 		for _, target := range targets {
 			filepath.Walk(target, func(_ string, fi os.FileInfo, err error) error {
@@ -94,8 +153,8 @@ func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, pa
 					return nil
 				}
 				if !fi.IsDir() {
-					total_files += 1
-					total_size += uint64(fi.Size())
+					atomic.AddUint64(&total_files, 1)
+					atomic.AddUint64(&total_size, uint64(fi.Size()))
 				}
 				return nil
 			})
@@ -128,30 +187,64 @@ func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, pa
 			}
 			lock.RUnlock()
 
-			fileNodeCid, err := buildFileNode(ctx, item, dagServ, cidBuilder, batchReadNum)
-			if err != nil {
-				ferr = err
-				return
+			var meta *MetaData
+			var rec *filehelper.SplitRecord
+			if o.sliceSize > 0 && item.Info.Size() > o.sliceSize {
+				log.Infof("import file as slices: %s", item.Path)
+				r, err := filehelper.BuildSplitFile(item, o.sliceSize, dagServ, cidBuilder)
+				if err != nil {
+					ferr = err
+					return
+				}
+				rec = r
+				meta = &MetaData{
+					Path:  item.Path,
+					Name:  item.Name,
+					Size:  item.Info.Size(),
+					Split: true,
+				}
+			} else {
+				fileNodeCid, err := buildFileNode(ctx, item, dagServ, cidBuilder, batchReadNum, obs, indexDir)
+				if err != nil {
+					ferr = err
+					return
+				}
+				meta = &MetaData{
+					Path: item.Path,
+					Name: item.Name,
+					Size: item.Info.Size(),
+					CID:  fileNodeCid.String(),
+				}
 			}
 			lock.Lock()
 			defer lock.Unlock()
-			records[item.Path] = &MetaData{
-				Path: item.Path,
-				Name: item.Name,
-				Size: item.Info.Size(),
-				CID:  fileNodeCid.String(),
+			records[item.Path] = meta
+			if rec != nil {
+				splits[item.Path] = rec
 			}
 
 			atomic.AddUint64(&importedSize, uint64(item.Info.Size()))
 
-			if total_size > 0 {
-				fmt.Printf("total %d files, imported %d files, %.2f %%\n", total_files, len(records), float64(len(records))/float64(total_files)*100)
-				fmt.Printf("total size: %d, imported size: %d, %.2f %%\n", total_size, importedSize, float64(importedSize)/float64(total_size)*100)
+			if dobs != nil {
+				dobs.DatasetProgress(progress.NewDatasetProgress(
+					atomic.LoadUint64(&total_files),
+					atomic.LoadUint64(&total_size),
+					uint64(len(records)),
+					atomic.LoadUint64(&importedSize),
+					start,
+				))
+			}
+			if rec == nil {
+				csvChan <- fmt.Sprintf("%s,%s,%d\n", strings.TrimPrefix(item.Path, prefix), meta.CID, item.Info.Size())
 			}
-			csvChan <- fmt.Sprintf("%s,%s,%d\n", strings.TrimPrefix(item.Path, prefix), fileNodeCid.String(), item.Info.Size())
 		}(item)
 	}
 	wg.Wait()
+	if len(splits) > 0 {
+		if err := saveSplitRecords(splits, splitPath); err != nil {
+			ferr = err
+		}
+	}
 	err = saveRecords(records, recordPath)
 	if err != nil {
 		ferr = err
@@ -159,22 +252,85 @@ func Import(ctx context.Context, bs bstore.Blockstore, cidBuilder cid.Prefix, pa
 	return ferr
 }
 
-func buildFileNode(ctx context.Context, item filehelper.Finfo, dagServ ipld.DAGService, cidBuilder cid.Builder, batchReadNum int) (root cid.Cid, err error) {
+func buildFileNode(ctx context.Context, item filehelper.Finfo, dagServ ipld.DAGService, cidBuilder cid.Builder, batchReadNum int, obs progress.Observer, indexDir string) (root cid.Cid, err error) {
 	f, err := os.Open(item.Path)
 	if err != nil {
 		return cid.Undef, err
 	}
 	defer f.Close()
 	log.Infof("import file: %s", item.Path)
-	rootcid, err := importer.BalanceNode(ctx, f, item.Info.Size(), dagServ, cidBuilder, batchReadNum)
+	opts := []importer.Option{importer.WithProgress(obs, item.Path, item.Info.Size())}
+	var ci *importer.ChunkIndex
+	if indexDir != "" {
+		ci = &importer.ChunkIndex{}
+		opts = append(opts, importer.WithChunkIndex(ci))
+	}
+	rootcid, err := importer.BalanceNode(ctx, f, item.Info.Size(), dagServ, cidBuilder, batchReadNum, opts...)
 	if err != nil {
 		return cid.Undef, err
 	}
+	if ci != nil {
+		if err := saveChunkIndex(ci, path.Join(indexDir, rootcid.String()+".idx")); err != nil {
+			return cid.Undef, err
+		}
+	}
 
 	return rootcid, nil
 
 }
 
+func saveChunkIndex(ci *importer.ChunkIndex, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := ci.WriteTo(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ReadChunkIndex loads the chunk index of the file imported as root,
+// saved in recordDir by an import with WithChunkIndex.
+func ReadChunkIndex(recordDir string, root cid.Cid) (*importer.ChunkIndex, error) {
+	f, err := os.Open(path.Join(recordDir, chunk_index_dir, root.String()+".idx"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return importer.ReadChunkIndex(f)
+}
+
+// ReadSplitRecords loads the records of the files imported as slices,
+// saved in recordDir by an import WithSplit, by file path.
+func ReadSplitRecords(recordDir string) (map[string]*filehelper.SplitRecord, error) {
+	return readSplitRecords(path.Join(recordDir, split_record_json))
+}
+
+func readSplitRecords(path string) (map[string]*filehelper.SplitRecord, error) {
+	res := make(map[string]*filehelper.SplitRecord)
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func saveSplitRecords(splits map[string]*filehelper.SplitRecord, path string) error {
+	bs, err := json.Marshal(splits)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bs, 0666)
+}
+
 func readRecords(path string) (map[string]*MetaData, error) {
 	res := make(map[string]*MetaData)
 	bs, err := ioutil.ReadFile(path)
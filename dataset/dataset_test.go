@@ -0,0 +1,177 @@
+package dataset_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/filedrive-team/filehelper/dataset"
+	"github.com/filedrive-team/filehelper/exporter"
+	"github.com/filedrive-team/filehelper/progress"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+func newStore() (blockstore.Blockstore, format.DAGService) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	return bs, merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+// writeFiles writes files of the given sizes under dir and returns their content by path.
+func writeFiles(t *testing.T, dir string, sizes map[string]int) map[string][]byte {
+	t.Helper()
+	res := make(map[string][]byte)
+	for name, size := range sizes {
+		path := filepath.Join(dir, name)
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		res[path] = data
+	}
+	return res
+}
+
+func TestImportSplit(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+	files := writeFiles(t, src, map[string]int{
+		"small": 1000,
+		"large": 3<<20 + 7,
+	})
+	recordDir := t.TempDir()
+	bs, dag := newStore()
+	prefix := merkledag.V1CidPrefix()
+	if err := dataset.ImportWithProgress(ctx, bs, prefix, 2, 4, src, recordDir, []string{src}, nil, dataset.WithSplit(1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	splits, err := dataset.ReadSplitRecords(recordDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := filepath.Join(src, "large")
+	if len(splits) != 1 || splits[large] == nil {
+		t.Fatalf("split records %v, expected the large file", splits)
+	}
+	rec := splits[large]
+	if len(rec.Slices) != 4 {
+		t.Fatalf("%d slices, expected 4", len(rec.Slices))
+	}
+	out := filepath.Join(t.TempDir(), "large")
+	if err := exporter.ExportSplit(ctx, []format.NodeGetter{dag}, rec, out, exporter.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, files[large]) {
+		t.Fatal("exported split file differs")
+	}
+
+	// a second import skips both files, the split record is kept
+	if err := dataset.ImportWithProgress(ctx, bs, prefix, 2, 4, src, recordDir, []string{src}, nil, dataset.WithSplit(1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	splits, err = dataset.ReadSplitRecords(recordDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if splits[large] == nil || splits[large].SHA256 != rec.SHA256 {
+		t.Fatal("split record lost by the second import")
+	}
+	csv, err := os.ReadFile(filepath.Join(recordDir, "record.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(csv, []byte("\n")); n != 1 {
+		t.Fatalf("record.csv has %d lines, expected the small file only", n)
+	}
+}
+
+type recorder struct {
+	lk       sync.Mutex
+	started  map[string]int64
+	chunks   map[string]int64
+	roots    map[string]cid.Cid
+	imported uint64
+	size     uint64
+}
+
+func (r *recorder) FileStarted(path string, size int64) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.started[path] = size
+}
+
+func (r *recorder) ChunkStored(p progress.FileProgress) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.chunks[p.Path]++
+}
+
+func (r *recorder) FileFinished(p progress.FileProgress, root cid.Cid, err error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if err == nil {
+		r.roots[p.Path] = root
+	}
+}
+
+func (r *recorder) DatasetProgress(p progress.DatasetProgress) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if p.ImportedFiles > r.imported {
+		r.imported, r.size = p.ImportedFiles, p.ImportedSize
+	}
+}
+
+func TestImportProgress(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+	files := writeFiles(t, src, map[string]int{
+		"a": 10,
+		"b": 2<<20 + 1,
+	})
+	bs, _ := newStore()
+	r := &recorder{
+		started: make(map[string]int64),
+		chunks:  make(map[string]int64),
+		roots:   make(map[string]cid.Cid),
+	}
+	recordDir := t.TempDir()
+	if err := dataset.ImportWithProgress(ctx, bs, merkledag.V1CidPrefix(), 2, 2, src, recordDir, []string{src}, r); err != nil {
+		t.Fatal(err)
+	}
+	records, err := os.ReadFile(filepath.Join(recordDir, "record.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for path, data := range files {
+		if r.started[path] != int64(len(data)) {
+			t.Fatalf("%s started with size %d, expected %d", path, r.started[path], len(data))
+		}
+		if want := int64(len(data)+1<<20-1) >> 20; r.chunks[path] != want {
+			t.Fatalf("%s: %d chunks stored, expected %d", path, r.chunks[path], want)
+		}
+		if !bytes.Contains(records, []byte(r.roots[path].String())) {
+			t.Fatalf("%s: finished with root %s, not in the records", path, r.roots[path])
+		}
+		total += uint64(len(data))
+	}
+	if r.imported != 2 || r.size != total {
+		t.Fatalf("dataset progress at %d files and %d bytes, expected 2 and %d", r.imported, r.size, total)
+	}
+}
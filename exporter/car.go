@@ -0,0 +1,226 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/filedrive-team/filehelper/carv1"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	legacy "github.com/ipfs/go-ipld-legacy"
+	"golang.org/x/xerrors"
+)
+
+// ExtractCarFile writes the DAGs of the roots of the car file at carPath to
+// target, without loading the blocks into a blockstore. Car v1 files, padded
+// or not, and car v2 files are accepted. With a single root the output is
+// the same as Export, with several roots each one is exported into target in
+// turn and opts.Name is ignored.
+// The car file is read sequentially as long as its blocks come in the order
+// the export needs them: the depth first pre-order of go-car, which is
+// carv1.OrderDFS, the default of BatchBuilder. Otherwise, as for OrderBFS cars
+// or the leaves first cars of carv1.CarWriter, the blocks skipped to reach the
+// one needed are indexed by their offset in the car file and read back from it
+// when needed. opts.Parallel
+// defaults to 1 to keep the reads in order.
+func ExtractCarFile(ctx context.Context, carPath, target string, opts Options) error {
+	f, err := os.Open(carPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	finfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var base int64
+	size := finfo.Size()
+	if h, err := carv1.ReadCarV2Header(io.NewSectionReader(f, 0, size)); err == nil {
+		base, size = int64(h.DataOffset), int64(h.DataSize)
+	}
+	g, err := newCarGetter(io.NewSectionReader(f, base, size), nil)
+	if err != nil {
+		return err
+	}
+	return extract(ctx, g, target, opts)
+}
+
+// ExtractCar is ExtractCarFile for a car v1 stream that cannot be read back,
+// such as a retrieval in progress. Blocks skipped to reach the one needed, and
+// blocks linked more than once by the nodes served so far, are spooled to a
+// temporary file in tmpDir, or the default temporary directory if empty. The
+// other blocks are served straight from the stream and not kept, a block
+// linked again by a node found later in the stream has to appear again in
+// the stream. The file is removed once done.
+func ExtractCar(ctx context.Context, r io.Reader, target, tmpDir string, opts Options) error {
+	spool, err := ioutil.TempFile(tmpDir, "extract-*.blocks")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+	g, err := newCarGetter(r, spool)
+	if err != nil {
+		return err
+	}
+	return extract(ctx, g, target, opts)
+}
+
+func extract(ctx context.Context, g *carGetter, target string, opts Options) error {
+	if opts.Parallel <= 0 {
+		opts.Parallel = 1
+	}
+	roots := g.cr.Header.Roots
+	if len(roots) > 1 {
+		opts.Name = ""
+	}
+	for _, root := range roots {
+		if err := Export(ctx, g, root, target, opts); err != nil {
+			return xerrors.Errorf("extract %s: %w", root, err)
+		}
+	}
+	return nil
+}
+
+type blockPos struct {
+	offset int64
+	size   int64
+}
+
+// carGetter serves the blocks of a car stream read forward on demand. The
+// position of every block read is kept when the car can be read back, with a
+// spool file only the positions of the blocks spooled.
+type carGetter struct {
+	lk    sync.Mutex
+	cr    *carv1.CarReader
+	ra    io.ReaderAt
+	spool *os.File
+	// end of the spool file
+	spoolSize int64
+	pos       map[cid.Cid]blockPos
+	// links to every block from the roots and the nodes served, with a spool file
+	refs map[cid.Cid]int
+	// blocks served from the stream and not spooled
+	dropped map[cid.Cid]struct{}
+}
+
+func newCarGetter(r io.Reader, spool *os.File) (*carGetter, error) {
+	cr, err := carv1.NewCarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	g := &carGetter{
+		cr:    cr,
+		spool: spool,
+		pos:   make(map[cid.Cid]blockPos),
+	}
+	if spool != nil {
+		g.ra = spool
+		g.refs = make(map[cid.Cid]int)
+		g.dropped = make(map[cid.Cid]struct{})
+		for _, root := range cr.Header.Roots {
+			g.refs[root]++
+		}
+	} else if ra, ok := r.(io.ReaderAt); ok {
+		g.ra = ra
+	} else {
+		return nil, xerrors.New("car source cannot be read back and no spool file given")
+	}
+	return g, nil
+}
+
+func (g *carGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	blk, err := g.getBlock(c)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := legacy.DecodeNode(ctx, blk)
+	if err != nil {
+		return nil, err
+	}
+	if g.refs != nil {
+		g.lk.Lock()
+		for _, l := range nd.Links() {
+			g.refs[l.Cid]++
+		}
+		g.lk.Unlock()
+	}
+	return nd, nil
+}
+
+func (g *carGetter) getBlock(c cid.Cid) (blocks.Block, error) {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+	if p, ok := g.pos[c]; ok {
+		data := make([]byte, p.size)
+		if _, err := g.ra.ReadAt(data, p.offset); err != nil {
+			return nil, xerrors.Errorf("read block %s: %w", c, err)
+		}
+		return blocks.NewBlockWithCid(data, c)
+	}
+	for {
+		s, err := g.cr.Next()
+		if err != nil {
+			if err == io.EOF {
+				if _, ok := g.dropped[c]; ok {
+					return nil, xerrors.Errorf("block %s served before and not spooled: %w", c, format.ErrNotFound)
+				}
+				return nil, format.ErrNotFound
+			}
+			return nil, err
+		}
+		if _, ok := g.pos[s.Cid]; ok {
+			continue
+		}
+		if !s.Cid.Equals(c) {
+			if err := g.record(s); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// the block is only spooled if another link to it is known
+		if g.spool == nil || g.refs[c] > 1 {
+			if err := g.record(s); err != nil {
+				return nil, err
+			}
+		} else {
+			g.dropped[c] = struct{}{}
+		}
+		return blocks.NewBlockWithCid(s.Data, c)
+	}
+}
+
+func (g *carGetter) record(s *carv1.Section) error {
+	if g.spool == nil {
+		g.pos[s.Cid] = blockPos{offset: int64(s.DataOffset), size: int64(len(s.Data))}
+		return nil
+	}
+	if _, err := g.spool.WriteAt(s.Data, g.spoolSize); err != nil {
+		return xerrors.Errorf("spool block %s: %w", s.Cid, err)
+	}
+	g.pos[s.Cid] = blockPos{offset: g.spoolSize, size: int64(len(s.Data))}
+	g.spoolSize += int64(len(s.Data))
+	return nil
+}
+
+func (g *carGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for _, c := range cids {
+			nd, err := g.Get(ctx, c)
+			select {
+			case out <- &format.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
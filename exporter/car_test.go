@@ -0,0 +1,134 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/ipfs/go-merkledag"
+)
+
+func TestExtractCarSpool(t *testing.T) {
+	ctx := context.Background()
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// random chunks then zero chunks, which share a single leaf
+	dir := t.TempDir()
+	data := make([]byte, 6<<20)
+	rand.Read(data[:2<<20])
+	path := filepath.Join(dir, "data")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dag := newDAG()
+	nd, err := filehelper.BuildFileNode(filehelper.Finfo{Path: path, Name: fi.Name(), Info: fi}, dag, cidBuilder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var car bytes.Buffer
+	if _, err := carv1.NewBatch(ctx, dag).Write(nd.Cid(), &car, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err := os.CreateTemp(dir, "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	g, err := newCarGetter(bytes.NewBuffer(car.Bytes()), spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "out")
+	if err := extract(ctx, g, target, Options{Name: "data"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(target, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("extracted file differs")
+	}
+	// only the zero leaf, linked four times, is spooled
+	zero, err := dag.Get(ctx, nd.Links()[2].Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(zero.RawData())); g.spoolSize != want {
+		t.Fatalf("%d bytes spooled, expected the %d of the zero leaf", g.spoolSize, want)
+	}
+}
+
+// TestExtractCarOrders checks that OrderDFS cars are read in one pass and
+// that the blocks of OrderBFS cars skipped to reach the ones needed are kept.
+func TestExtractCarOrders(t *testing.T) {
+	ctx := context.Background()
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"a", "b"} {
+		data := make([]byte, 2<<20+i)
+		rand.Read(data)
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dag := newDAG()
+	nd := buildTree(t, dag, src, cidBuilder)
+	want := readTree(t, src)
+	for _, tc := range []struct {
+		order   carv1.Order
+		skipped bool
+	}{
+		{carv1.OrderDFS, false},
+		{carv1.OrderBFS, true},
+	} {
+		var car bytes.Buffer
+		if _, err := carv1.NewBatch(ctx, dag, carv1.WithOrder(tc.order)).Write(nd.Cid(), &car, 1); err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		spool, err := os.CreateTemp(dir, "spool")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer spool.Close()
+		g, err := newCarGetter(bytes.NewReader(car.Bytes()), spool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := filepath.Join(dir, "out")
+		if err := extract(ctx, g, target, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		got := readTree(t, target)
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d files extracted", tc.order, len(got))
+		}
+		for name, data := range want {
+			if !bytes.Equal(got[name], data) {
+				t.Fatalf("%s: %s differs", tc.order, name)
+			}
+		}
+		if skipped := g.spoolSize > 0; skipped != tc.skipped {
+			t.Fatalf("%s: %d bytes spooled", tc.order, g.spoolSize)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package exporter writes UnixFS DAGs stored in a blockstore back to files on disk.
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/filedrive-team/filehelper/carv1"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	format "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	"golang.org/x/xerrors"
+
+	pb "github.com/ipfs/go-unixfs/pb"
+)
+
+var log = logging.Logger("filehelper/exporter")
+
+var errReadOnly = xerrors.New("exporter: dag service is read only")
+
+// ExistPolicy decides what happens when a file to be exported already exists.
+type ExistPolicy int
+
+const (
+	// ExistFail aborts the export with an error.
+	ExistFail ExistPolicy = iota
+	// ExistOverwrite replaces the existing file.
+	ExistOverwrite
+	// ExistSkip keeps the existing file untouched and goes on.
+	ExistSkip
+)
+
+type Options struct {
+	// Parallel is the number of blocks fetched ahead of the writer, defaults to 8
+	Parallel int
+	// Exist is applied to files that are already present in the target
+	Exist ExistPolicy
+	// Name is used for the output file when the root is a file,
+	// the root cid is used if it is empty
+	Name string
+}
+
+// ExportBlockstore is the same as Export, reading blocks from bs.
+func ExportBlockstore(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, target string, opts Options) error {
+	return Export(ctx, carv1.NewOfflineNodeGetter(bs), root, target, opts)
+}
+
+// Export writes the DAG under root to the target directory.
+// A directory root has its entries written into target,
+// a file root is written as target/Name.
+// The size of every written file is checked against its UnixFS node.
+func Export(ctx context.Context, ng format.NodeGetter, root cid.Cid, target string, opts Options) error {
+	if opts.Parallel <= 0 {
+		opts.Parallel = 8
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	nd, err := ng.Get(ctx, root)
+	if err != nil {
+		return err
+	}
+	e := &exporter{ng: ng, opts: opts}
+	if isDir(nd) {
+		return e.writeDir(ctx, nd, target)
+	}
+	name := opts.Name
+	if name == "" {
+		name = root.String()
+	}
+	return e.writeNode(ctx, nd, filepath.Join(target, name))
+}
+
+type exporter struct {
+	ng   format.NodeGetter
+	opts Options
+}
+
+func (e *exporter) writeNode(ctx context.Context, nd format.Node, path string) error {
+	if isDir(nd) {
+		return e.writeDir(ctx, nd, path)
+	}
+	if pn, ok := nd.(*merkledag.ProtoNode); ok {
+		fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+		if err != nil {
+			return xerrors.Errorf("%s: %w", nd.Cid(), err)
+		}
+		if fsn.Type() == pb.Data_Symlink {
+			return e.writeSymlink(string(fsn.Data()), path)
+		}
+	}
+	return e.writeFile(ctx, nd, path)
+}
+
+func (e *exporter) writeDir(ctx context.Context, nd format.Node, path string) error {
+	if fi, err := os.Lstat(path); err == nil && !fi.IsDir() {
+		if err := e.replace(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	dir, err := uio.NewDirectoryFromNode(readOnlyDag{e.ng}, nd)
+	if err != nil {
+		return err
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if !validName(link.Name) {
+			return xerrors.Errorf("invalid entry name %q in directory %s", link.Name, nd.Cid())
+		}
+	}
+	return fetchOrdered(ctx, e.ng, links, e.opts.Parallel, func(i int, child format.Node) error {
+		return e.writeNode(ctx, child, filepath.Join(path, links[i].Name))
+	})
+}
+
+func (e *exporter) writeSymlink(target, path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		if e.opts.Exist == ExistSkip {
+			return nil
+		}
+		if err := e.replace(path); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, path)
+}
+
+func (e *exporter) writeFile(ctx context.Context, nd format.Node, path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		if e.opts.Exist == ExistSkip {
+			log.Infof("skip existing file: %s", path)
+			return nil
+		}
+		if err := e.replace(path); err != nil {
+			return err
+		}
+	}
+	expected, err := fileSize(nd)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	written, err := e.writeData(ctx, bw, nd)
+	if err != nil {
+		return xerrors.Errorf("export %s: %w", path, err)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if written != expected {
+		return xerrors.Errorf("export %s: wrote %d bytes, expected %d", path, written, expected)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if uint64(fi.Size()) != expected {
+		return xerrors.Errorf("export %s: file size is %d, expected %d", path, fi.Size(), expected)
+	}
+	return nil
+}
+
+// writeData writes the file content of the DAG under nd to w
+// and returns the number of bytes written.
+func (e *exporter) writeData(ctx context.Context, w io.Writer, nd format.Node) (uint64, error) {
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		written, err := w.Write(n.RawData())
+		return uint64(written), err
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return 0, xerrors.Errorf("%s: %w", nd.Cid(), err)
+		}
+		if t := fsn.Type(); t != pb.Data_File && t != pb.Data_Raw {
+			return 0, xerrors.Errorf("%s: unexpected %s node in file", nd.Cid(), t)
+		}
+		links := n.Links()
+		if len(links) != fsn.NumChildren() {
+			return 0, xerrors.Errorf("%s: %d links but %d block sizes", nd.Cid(), len(links), fsn.NumChildren())
+		}
+		written, err := w.Write(fsn.Data())
+		if err != nil {
+			return 0, err
+		}
+		total := uint64(written)
+		err = fetchOrdered(ctx, e.ng, links, e.opts.Parallel, func(i int, child format.Node) error {
+			cw, err := e.writeData(ctx, w, child)
+			if err != nil {
+				return err
+			}
+			if cw != fsn.BlockSize(i) {
+				return xerrors.Errorf("%s: child %d has %d bytes, expected %d", nd.Cid(), i, cw, fsn.BlockSize(i))
+			}
+			total += cw
+			return nil
+		})
+		return total, err
+	default:
+		return 0, xerrors.Errorf("%s: unsupported node type %T", nd.Cid(), nd)
+	}
+}
+
+func (e *exporter) replace(path string) error {
+	if e.opts.Exist != ExistOverwrite {
+		return xerrors.Errorf("%s already exists", path)
+	}
+	return os.RemoveAll(path)
+}
+
+func isDir(nd format.Node) bool {
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return false
+	}
+	return fsn.IsDir()
+}
+
+func fileSize(nd format.Node) (uint64, error) {
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		return uint64(len(n.RawData())), nil
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return 0, xerrors.Errorf("%s: %w", nd.Cid(), err)
+		}
+		return fsn.FileSize(), nil
+	default:
+		return 0, xerrors.Errorf("%s: unsupported node type %T", nd.Cid(), nd)
+	}
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,185 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	uio "github.com/ipfs/go-unixfs/io"
+)
+
+// buildTree imports the file or directory at path into dag.
+func buildTree(t *testing.T, dag format.DAGService, path string, cidBuilder cid.Builder) format.Node {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		nd, err := filehelper.BuildFileNode(filehelper.Finfo{Path: path, Name: fi.Name(), Info: fi}, dag, cidBuilder)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		return nd
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := uio.NewDirectory(dag)
+	dir.SetCidBuilder(cidBuilder)
+	for _, e := range entries {
+		child := buildTree(t, dag, filepath.Join(path, e.Name()), cidBuilder)
+		if err := dir.AddChild(context.Background(), e.Name(), child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dag.Add(context.Background(), nd); err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+// readTree maps the slash separated path of every file under root to its content,
+// and of every directory to nil.
+func readTree(t *testing.T, root string) map[string][]byte {
+	t.Helper()
+	tree := make(map[string][]byte)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			tree[filepath.ToSlash(rel)] = nil
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = append([]byte{}, data...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestExportDirectory(t *testing.T) {
+	ctx := context.Background()
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	files := map[string]int{
+		"empty":          0,
+		"small":          10,
+		"a/chunked":      3<<20 + 5,
+		"a/b/nested":     100,
+		"a/b/c/deep":     1 << 20,
+		"a/b/c/empty":    0,
+		"other/sibling":  4096,
+		"other/sibling2": 4096,
+	}
+	for name, size := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(src, "emptydir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	want := readTree(t, src)
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	root := buildTree(t, dag, src, cidBuilder).Cid()
+
+	for _, parallel := range []int{1, 8} {
+		target := t.TempDir()
+		if err := ExportBlockstore(ctx, bs, root, target, Options{Parallel: parallel}); err != nil {
+			t.Fatalf("parallel %d: %s", parallel, err)
+		}
+		got := readTree(t, target)
+		if len(got) != len(want) {
+			t.Fatalf("parallel %d: exported %d entries, expected %d", parallel, len(got), len(want))
+		}
+		for name, data := range want {
+			gd, ok := got[name]
+			if !ok {
+				t.Fatalf("parallel %d: %s not exported", parallel, name)
+			}
+			if (data == nil) != (gd == nil) || !bytes.Equal(gd, data) {
+				t.Fatalf("parallel %d: %s differs", parallel, name)
+			}
+		}
+
+		// a second export meets the files of the first one
+		if err := ExportBlockstore(ctx, bs, root, target, Options{}); err == nil {
+			t.Fatalf("parallel %d: export over existing files did not fail", parallel)
+		}
+		if err := ExportBlockstore(ctx, bs, root, target, Options{Exist: ExistSkip}); err != nil {
+			t.Fatalf("parallel %d: skip: %s", parallel, err)
+		}
+		if err := ExportBlockstore(ctx, bs, root, target, Options{Exist: ExistOverwrite}); err != nil {
+			t.Fatalf("parallel %d: overwrite: %s", parallel, err)
+		}
+	}
+}
+
+func TestExportFile(t *testing.T) {
+	ctx := context.Background()
+	cidBuilder, err := merkledag.PrefixForCidVersion(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, 1 << 20, 2<<20 + 1} {
+		dir := t.TempDir()
+		item, data := writeRandFile(t, dir, size)
+		dag := newDAG()
+		nd, err := filehelper.BuildFileNode(item, dag, cidBuilder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := t.TempDir()
+		if err := Export(ctx, dag, nd.Cid(), target, Options{Name: "out"}); err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		got, err := os.ReadFile(filepath.Join(target, "out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: exported file differs", size)
+		}
+	}
+}
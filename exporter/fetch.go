@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+type fetchResult struct {
+	nd  format.Node
+	err error
+}
+
+// fetchOrdered loads the nodes behind links with at most parallel requests
+// running ahead of the consumer and hands them to fn in link order.
+// Every goroutine it starts has finished or been cancelled when it returns.
+func fetchOrdered(ctx context.Context, ng format.NodeGetter, links []*format.Link, parallel int, fn func(i int, nd format.Node) error) error {
+	if len(links) == 0 {
+		return nil
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan fetchResult, len(links))
+	for i := range results {
+		results[i] = make(chan fetchResult, 1)
+	}
+	window := make(chan struct{}, parallel)
+	go func() {
+		for i, link := range links {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int, link *format.Link) {
+				nd, err := ng.Get(ctx, link.Cid)
+				if err != nil {
+					// try get one more time
+					nd, err = ng.Get(ctx, link.Cid)
+				}
+				results[i] <- fetchResult{nd: nd, err: err}
+			}(i, link)
+		}
+	}()
+
+	for i := range links {
+		var res fetchResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-window
+		if res.err != nil {
+			return res.err
+		}
+		if err := fn(i, res.nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOnlyDag lets a NodeGetter be used where go-unixfs asks for a DAGService,
+// e.g. to enumerate HAMT sharded directories.
+type readOnlyDag struct {
+	format.NodeGetter
+}
+
+func (readOnlyDag) Add(context.Context, format.Node) error {
+	return errReadOnly
+}
+
+func (readOnlyDag) AddMany(context.Context, []format.Node) error {
+	return errReadOnly
+}
+
+func (readOnlyDag) Remove(context.Context, cid.Cid) error {
+	return errReadOnly
+}
+
+func (readOnlyDag) RemoveMany(context.Context, []cid.Cid) error {
+	return errReadOnly
+}
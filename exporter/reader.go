@@ -0,0 +1,223 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"golang.org/x/xerrors"
+
+	pb "github.com/ipfs/go-unixfs/pb"
+)
+
+// number of internal nodes kept by a FileReader
+const readerCacheSize = 64
+
+// FileReader gives random access to the content of a UnixFS file DAG.
+// Only the branches covering a requested range are fetched, located by the
+// blocksizes of each node. ReadAt is safe for concurrent use,
+// Read and Seek share an offset and are not.
+type FileReader struct {
+	ctx      context.Context
+	cache    *nodeCache
+	root     format.Node
+	size     int64
+	offset   int64
+	parallel int
+}
+
+type fileNode struct {
+	nd  *merkledag.ProtoNode
+	fsn *unixfs.FSNode
+}
+
+// NewFileReader opens the file DAG under root, parallel is the number of
+// blocks fetched at once when a read spans several of them.
+func NewFileReader(ctx context.Context, ng format.NodeGetter, root cid.Cid, parallel int) (*FileReader, error) {
+	if parallel <= 0 {
+		parallel = 8
+	}
+	nd, err := ng.Get(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if isDir(nd) {
+		return nil, xerrors.Errorf("%s is a directory", root)
+	}
+	size, err := fileSize(nd)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReader{
+		ctx:      ctx,
+		cache:    &nodeCache{NodeGetter: ng, nodes: make(map[cid.Cid]format.Node)},
+		root:     nd,
+		size:     int64(size),
+		parallel: parallel,
+	}, nil
+}
+
+// Size is the size of the file content.
+func (r *FileReader) Size() int64 {
+	return r.size
+}
+
+func (r *FileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset: %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if int64(want) > r.size-off {
+		p = p[:r.size-off]
+	}
+	if err := r.readNode(r.root, p, uint64(off)); err != nil {
+		return 0, err
+	}
+	if len(p) < want {
+		return len(p), io.EOF
+	}
+	return len(p), nil
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, xerrors.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, xerrors.Errorf("negative offset: %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// readNode fills p with the content of nd starting at off, relative to the start of nd.
+// The caller makes sure the whole of p lies within nd.
+func (r *FileReader) readNode(nd format.Node, p []byte, off uint64) error {
+	if rn, ok := nd.(*merkledag.RawNode); ok {
+		data := rn.RawData()
+		if off+uint64(len(p)) > uint64(len(data)) {
+			return xerrors.Errorf("%s: read out of the block bounds", nd.Cid())
+		}
+		copy(p, data[off:])
+		return nil
+	}
+	cn, err := r.decode(nd)
+	if err != nil {
+		return err
+	}
+	end := off + uint64(len(p))
+	data := cn.fsn.Data()
+	// the parent sizes may claim more than the node holds, do not leave zeros in p
+	extent := uint64(len(data))
+	for _, bz := range cn.fsn.BlockSizes() {
+		extent += bz
+	}
+	if end > extent {
+		return xerrors.Errorf("%s: node data shorter than blocksizes, holds %d bytes, read up to %d", nd.Cid(), extent, end)
+	}
+	if off < uint64(len(data)) {
+		copy(p, data[off:])
+	}
+
+	// collect the children overlapping [off, end)
+	links := cn.nd.Links()
+	targets := make([]*format.Link, 0)
+	starts := make([]uint64, 0)
+	sizes := make([]uint64, 0)
+	pos := uint64(len(data))
+	for i, bz := range cn.fsn.BlockSizes() {
+		if pos >= end {
+			break
+		}
+		if pos+bz > off {
+			targets = append(targets, links[i])
+			starts = append(starts, pos)
+			sizes = append(sizes, bz)
+		}
+		pos += bz
+	}
+	return fetchOrdered(r.ctx, r.cache, targets, r.parallel, func(i int, child format.Node) error {
+		var coff uint64
+		dst := p
+		if starts[i] < off {
+			coff = off - starts[i]
+		} else {
+			dst = p[starts[i]-off:]
+		}
+		if rest := sizes[i] - coff; uint64(len(dst)) > rest {
+			dst = dst[:rest]
+		}
+		return r.readNode(child, dst, coff)
+	})
+}
+
+func (r *FileReader) decode(nd format.Node) (*fileNode, error) {
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return nil, xerrors.Errorf("%s: unsupported node type %T", nd.Cid(), nd)
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %w", nd.Cid(), err)
+	}
+	if t := fsn.Type(); t != pb.Data_File && t != pb.Data_Raw {
+		return nil, xerrors.Errorf("%s: unexpected %s node in file", nd.Cid(), t)
+	}
+	if len(pn.Links()) != fsn.NumChildren() {
+		return nil, xerrors.Errorf("%s: %d links but %d block sizes", nd.Cid(), len(pn.Links()), fsn.NumChildren())
+	}
+	return &fileNode{nd: pn, fsn: fsn}, nil
+}
+
+// nodeCache keeps the internal nodes of a file, so that
+// consecutive reads do not fetch the same branches again.
+type nodeCache struct {
+	format.NodeGetter
+
+	lk    sync.Mutex
+	nodes map[cid.Cid]format.Node
+}
+
+func (c *nodeCache) Get(ctx context.Context, k cid.Cid) (format.Node, error) {
+	c.lk.Lock()
+	nd, ok := c.nodes[k]
+	c.lk.Unlock()
+	if ok {
+		return nd, nil
+	}
+	nd, err := c.NodeGetter.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	if len(nd.Links()) > 0 {
+		c.lk.Lock()
+		if len(c.nodes) >= readerCacheSize {
+			c.nodes = make(map[cid.Cid]format.Node)
+		}
+		c.nodes[k] = nd
+		c.lk.Unlock()
+	}
+	return nd, nil
+}
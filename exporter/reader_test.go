@@ -0,0 +1,57 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+// A parent claiming more bytes for a child than the child holds must fail
+// the read instead of leaving zeros in the buffer.
+func TestFileReaderShortNode(t *testing.T) {
+	ctx := context.Background()
+	dag := newDAG()
+
+	leafFsn := unixfs.NewFSNode(unixfs.TFile)
+	leafFsn.SetData([]byte("abc"))
+	leafData, err := leafFsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := merkledag.NodeWithData(leafData)
+	if err := dag.Add(ctx, leaf); err != nil {
+		t.Fatal(err)
+	}
+
+	rootFsn := unixfs.NewFSNode(unixfs.TFile)
+	rootFsn.AddBlockSize(10)
+	rootData, err := rootFsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := merkledag.NodeWithData(rootData)
+	if err := root.AddNodeLink("", leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := dag.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewFileReader(ctx, dag, root.Cid(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != 10 {
+		t.Fatalf("size %d, expected 10", r.Size())
+	}
+	p := make([]byte, 3)
+	if _, err := r.ReadAt(p, 0); err != nil || string(p) != "abc" {
+		t.Fatalf("read %q: %v", p, err)
+	}
+	p = make([]byte, 10)
+	if _, err := r.ReadAt(p, 0); err == nil {
+		t.Fatal("short node read without error")
+	}
+}
@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// ExportSplit streams the slices of rec in order into outPath and checks
+// the sha256 of the result against the record.
+// Each slice is read from the first getter holding its root, so the slices
+// may be spread over several blockstores or car files (see carv1.OpenCarNodeGetter).
+// The file is written to outPath.part and renamed once verified.
+func ExportSplit(ctx context.Context, getters []format.NodeGetter, rec *filehelper.SplitRecord, outPath string, opts Options) error {
+	if opts.Parallel <= 0 {
+		opts.Parallel = 8
+	}
+	if err := rec.Verify(); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(outPath); err == nil {
+		switch opts.Exist {
+		case ExistSkip:
+			log.Infof("skip existing file: %s", outPath)
+			return nil
+		case ExistFail:
+			return xerrors.Errorf("%s already exists", outPath)
+		}
+	}
+
+	tmpPath := outPath + ".part"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(tmpPath)
+	}()
+	h := sha256.New()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	w := io.MultiWriter(bw, h)
+	for i, s := range rec.Slices {
+		c, err := cid.Decode(s.CID)
+		if err != nil {
+			return xerrors.Errorf("slice %d: %w", i, err)
+		}
+		nd, ng, err := getFromAny(ctx, getters, c)
+		if err != nil {
+			return xerrors.Errorf("slice %d: %w", i, err)
+		}
+		e := &exporter{ng: ng, opts: opts}
+		written, err := e.writeData(ctx, w, nd)
+		if err != nil {
+			return xerrors.Errorf("slice %d: %w", i, err)
+		}
+		if written != uint64(s.Size) {
+			return xerrors.Errorf("slice %d: wrote %d bytes, expected %d", i, written, s.Size)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != rec.SHA256 {
+		return xerrors.Errorf("checksum mismatch for %s: got %s, expected %s", rec.Path, sum, rec.SHA256)
+	}
+	return os.Rename(tmpPath, outPath)
+}
+
+func getFromAny(ctx context.Context, getters []format.NodeGetter, c cid.Cid) (format.Node, format.NodeGetter, error) {
+	var lastErr error = format.ErrNotFound
+	for _, ng := range getters {
+		nd, err := ng.Get(ctx, c)
+		if err == nil {
+			return nd, ng, nil
+		}
+		lastErr = err
+	}
+	return nil, nil, xerrors.Errorf("%s: %w", c, lastErr)
+}
@@ -0,0 +1,112 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/ipfs/go-blockservice"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+func newDAG() format.DAGService {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	return merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+func writeRandFile(t *testing.T, dir string, size int) (filehelper.Finfo, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(dir, "data")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filehelper.Finfo{Path: path, Name: fi.Name(), Info: fi}, data
+}
+
+func TestExportSplit(t *testing.T) {
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		fileSize  int
+		sliceSize int64
+	}{
+		{1, 2},
+		{2, 2},
+		{3, 2},
+		{10, 2},
+		{10, 3},
+		{10, 9},
+		{10, 10},
+		{10, 11},
+		{3<<20 + 5, 1<<20 - 1},
+		{3<<20 + 5, 1 << 20},
+		{3<<20 + 5, 1<<20 + 1},
+		{3<<20 + 5, 3<<20 + 5},
+	}
+	for _, tc := range cases {
+		dir := t.TempDir()
+		item, data := writeRandFile(t, dir, tc.fileSize)
+		dag := newDAG()
+		rec, err := filehelper.BuildSplitFile(item, tc.sliceSize, dag, cidBuilder)
+		if err != nil {
+			t.Fatalf("file %d slice %d: %s", tc.fileSize, tc.sliceSize, err)
+		}
+		if err := rec.Verify(); err != nil {
+			t.Fatalf("file %d slice %d: %s", tc.fileSize, tc.sliceSize, err)
+		}
+		slices, err := filehelper.SplitFinfo(item, tc.sliceSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(slices) != len(rec.Slices) {
+			t.Fatalf("file %d slice %d: %d slices, recorded %d", tc.fileSize, tc.sliceSize, len(slices), len(rec.Slices))
+		}
+		// each slice built on its own gives the recorded cid
+		for i, slice := range slices {
+			nd, err := filehelper.BuildFileNode(slice, newDAG(), cidBuilder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nd.Cid().String() != rec.Slices[i].CID {
+				t.Fatalf("file %d slice %d: slice %d is %s, recorded %s", tc.fileSize, tc.sliceSize, i, nd.Cid(), rec.Slices[i].CID)
+			}
+		}
+		out := filepath.Join(dir, "out")
+		if err := ExportSplit(context.Background(), []format.NodeGetter{dag}, rec, out, Options{}); err != nil {
+			t.Fatalf("file %d slice %d: %s", tc.fileSize, tc.sliceSize, err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("file %d slice %d: exported file differs", tc.fileSize, tc.sliceSize)
+		}
+	}
+}
+
+func TestSplitFinfoInvalidSize(t *testing.T) {
+	item, _ := writeRandFile(t, t.TempDir(), 10)
+	for _, size := range []int64{-1, 0, 1} {
+		if _, err := filehelper.SplitFinfo(item, size); err == nil {
+			t.Fatalf("slice size %d accepted", size)
+		}
+	}
+}
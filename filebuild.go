@@ -10,6 +10,7 @@ import (
 	"github.com/ipfs/go-unixfs/importer/balanced"
 	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
 
+	"github.com/filedrive-team/filehelper/progress"
 	ipld "github.com/ipfs/go-ipld-format"
 )
 
@@ -17,11 +18,17 @@ const UnixfsLinksPerLevel = 1 << 10
 const UnixfsChunkSize uint64 = 1 << 20
 
 func BuildFileNode(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder) (node ipld.Node, err error) {
+	return BuildFileNodeWithProgress(item, bufDs, cidBuilder, nil)
+}
+
+// BuildFileNodeWithProgress is BuildFileNode reporting bytes read and leaves stored to obs.
+func BuildFileNodeWithProgress(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder, obs progress.Observer) (node ipld.Node, err error) {
 	var r io.Reader
 	f, err := os.Open(item.Path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	r = f
 
 	// read all data of item
@@ -34,6 +41,27 @@ func BuildFileNode(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder) (n
 		}
 	}
 
+	size := item.Info.Size()
+	if item.SeekStart > 0 || item.SeekEnd > 0 {
+		end := item.SeekEnd
+		if end == 0 {
+			end = size - 1
+		}
+		size = end - item.SeekStart + 1
+	}
+	tracker := progress.NewTracker(obs, item.Path, size)
+	defer func() {
+		if node != nil {
+			tracker.Finish(node.Cid(), err)
+		} else {
+			tracker.Finish(cid.Undef, err)
+		}
+	}()
+	if obs != nil {
+		r = &progressReader{r: r, tracker: tracker}
+		bufDs = &progressDag{DAGService: bufDs, tracker: tracker}
+	}
+
 	params := ihelper.DagBuilderParams{
 		Maxlinks:   UnixfsLinksPerLevel,
 		RawLeaves:  false,
@@ -0,0 +1,141 @@
+package filehelper
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filedrive-team/filehelper/importer"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"golang.org/x/xerrors"
+)
+
+// BuildFileNodeWithIndex is BuildFileNode also returning the chunk index of the file.
+func BuildFileNodeWithIndex(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder) (ipld.Node, *importer.ChunkIndex, error) {
+	d := &indexDag{
+		DAGService: bufDs,
+		leaves:     make(map[cid.Cid]uint64),
+		parents:    make(map[cid.Cid]*indexParent),
+	}
+	node, err := BuildFileNode(item, d, cidBuilder)
+	if err != nil {
+		return nil, nil, err
+	}
+	ci := &importer.ChunkIndex{
+		Root:    node.Cid(),
+		Entries: make([]importer.ChunkEntry, 0),
+	}
+	if err := d.index(ci, node.Cid(), -1); err != nil {
+		return nil, nil, err
+	}
+	return node, ci, nil
+}
+
+// indexDag records the nodes added by the dag builder, the index is then
+// built by walking the dag from its root, whatever order they were added in.
+type indexDag struct {
+	ipld.DAGService
+
+	lk sync.Mutex
+	// data size of every leaf
+	leaves map[cid.Cid]uint64
+	// children of every node with links
+	parents map[cid.Cid]*indexParent
+}
+
+type indexParent struct {
+	links []cid.Cid
+	sizes []uint64
+}
+
+func (d *indexDag) Add(ctx context.Context, nd ipld.Node) error {
+	if err := d.record(nd); err != nil {
+		return err
+	}
+	return d.DAGService.Add(ctx, nd)
+}
+
+func (d *indexDag) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		if err := d.record(nd); err != nil {
+			return err
+		}
+	}
+	return d.DAGService.AddMany(ctx, nds)
+}
+
+func (d *indexDag) record(nd ipld.Node) error {
+	if len(nd.Links()) == 0 {
+		data, err := unixfs.ReadUnixFSNodeData(nd)
+		if err != nil {
+			return err
+		}
+		d.lk.Lock()
+		d.leaves[nd.Cid()] = uint64(len(data))
+		d.lk.Unlock()
+		return nil
+	}
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return xerrors.Errorf("%s: unexpected %T node with links", nd.Cid(), nd)
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return xerrors.Errorf("%s: %w", nd.Cid(), err)
+	}
+	// the index only knows the data of leaves
+	if len(fsn.Data()) > 0 {
+		return xerrors.Errorf("%s: node with links holds data", nd.Cid())
+	}
+	if fsn.NumChildren() != len(nd.Links()) {
+		return xerrors.Errorf("%s: %d links but %d block sizes", nd.Cid(), len(nd.Links()), fsn.NumChildren())
+	}
+	p := &indexParent{
+		links: make([]cid.Cid, 0, len(nd.Links())),
+		sizes: fsn.BlockSizes(),
+	}
+	for _, l := range nd.Links() {
+		p.links = append(p.links, l.Cid)
+	}
+	d.lk.Lock()
+	d.parents[nd.Cid()] = p
+	d.lk.Unlock()
+	return nil
+}
+
+// index appends the leaves under c to ci in file order, size is the
+// size of the data under c claimed by its parent, or -1 for the root.
+func (d *indexDag) index(ci *importer.ChunkIndex, c cid.Cid, size int64) error {
+	if p, ok := d.parents[c]; ok {
+		start := ci.Size
+		for i, l := range p.links {
+			if err := d.index(ci, l, int64(p.sizes[i])); err != nil {
+				return err
+			}
+		}
+		if size >= 0 && ci.Size-start != uint64(size) {
+			return xerrors.Errorf("%s: %d bytes under the node, its parent claims %d", c, ci.Size-start, size)
+		}
+		return nil
+	}
+	length, ok := d.leaves[c]
+	if !ok {
+		return xerrors.Errorf("%s: node not added by the dag builder", c)
+	}
+	if size >= 0 && length != uint64(size) {
+		return xerrors.Errorf("%s: leaf holds %d bytes, its parent claims %d", c, length, size)
+	}
+	if length == 0 {
+		return nil
+	}
+	ci.Entries = append(ci.Entries, importer.ChunkEntry{
+		Idx:    len(ci.Entries),
+		Offset: ci.Size,
+		Length: length,
+		Cid:    c,
+	})
+	ci.Size += length
+	return nil
+}
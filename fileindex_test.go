@@ -0,0 +1,146 @@
+package filehelper_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/filedrive-team/filehelper/importer"
+	"github.com/ipfs/go-blockservice"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+const chunk = int(filehelper.UnixfsChunkSize)
+
+func newDAG() format.DAGService {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	return merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+// readRange reads length bytes from offset through the leaves the index gives for them.
+func readRange(t *testing.T, dag format.DAGService, ci *importer.ChunkIndex, offset, length uint64) []byte {
+	t.Helper()
+	entries := ci.Lookup(offset, length)
+	if len(entries) == 0 {
+		t.Fatalf("no leaf for %d bytes at %d", length, offset)
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		nd, err := dag.Get(context.Background(), e.Cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := unixfs.ReadUnixFSNodeData(nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(data)) != e.Length {
+			t.Fatalf("leaf %d holds %d bytes, indexed %d", e.Idx, len(data), e.Length)
+		}
+		buf.Write(data)
+	}
+	start := offset - entries[0].Offset
+	if start+length > uint64(buf.Len()) {
+		t.Fatalf("leaves of %d bytes at %d end before the range", length, offset)
+	}
+	return buf.Bytes()[start : start+length]
+}
+
+func TestBuildFileNodeWithIndex(t *testing.T) {
+	ctx := context.Background()
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 4*chunk + 123
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dag := newDAG()
+	nd, ci, err := filehelper.BuildFileNodeWithIndex(filehelper.Finfo{Path: path, Name: fi.Name(), Info: fi}, dag, cidBuilder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ci.Root.Equals(nd.Cid()) || ci.Size != uint64(size) || len(ci.Entries) != 5 {
+		t.Fatalf("index of %s: root %s, %d bytes in %d entries", nd.Cid(), ci.Root, ci.Size, len(ci.Entries))
+	}
+
+	// the importer gives the same index
+	ici := &importer.ChunkIndex{}
+	root, err := importer.BalanceNode(ctx, bytes.NewReader(data), int64(size), newDAG(), cidBuilder, 4, importer.WithChunkIndex(ici))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !root.Equals(nd.Cid()) || !reflect.DeepEqual(ici, ci) {
+		t.Fatalf("importer index differs:\n%+v\n%+v", ici, ci)
+	}
+
+	cases := []struct {
+		name           string
+		offset, length int
+	}{
+		{"first chunk", 0, 10},
+		{"whole first chunk", 0, chunk},
+		{"middle chunk", 2*chunk + 7, 100},
+		{"across chunks", chunk - 5, 10},
+		{"last chunk", size - 3, 3},
+		{"whole file", 0, size},
+	}
+	for _, tc := range cases {
+		got := readRange(t, dag, ci, uint64(tc.offset), uint64(tc.length))
+		if !bytes.Equal(got, data[tc.offset:tc.offset+tc.length]) {
+			t.Fatalf("%s: read through the index differs", tc.name)
+		}
+	}
+	if e := ci.Lookup(uint64(size), 1); e != nil {
+		t.Fatalf("lookup past the end gives %v", e)
+	}
+
+	var buf bytes.Buffer
+	if _, err := ci.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rci, err := importer.ReadChunkIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rci, ci) {
+		t.Fatalf("index read back differs:\n%+v\n%+v", rci, ci)
+	}
+}
+
+func TestBuildFileNodeWithIndexEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, ci, err := filehelper.BuildFileNodeWithIndex(filehelper.Finfo{Path: path, Name: fi.Name(), Info: fi}, newDAG(), merkledag.V1CidPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ci.Root.Equals(nd.Cid()) || ci.Size != 0 || len(ci.Entries) != 0 {
+		t.Fatalf("index of an empty file: %+v", ci)
+	}
+}
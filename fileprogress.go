@@ -0,0 +1,48 @@
+package filehelper
+
+import (
+	"context"
+	"io"
+
+	"github.com/filedrive-team/filehelper/progress"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+type progressReader struct {
+	r       io.Reader
+	tracker *progress.Tracker
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.tracker.Read(n)
+	return n, err
+}
+
+// progressDag counts the leaves added by the dag builder as stored chunks.
+type progressDag struct {
+	ipld.DAGService
+	tracker *progress.Tracker
+}
+
+func (d *progressDag) Add(ctx context.Context, nd ipld.Node) error {
+	if err := d.DAGService.Add(ctx, nd); err != nil {
+		return err
+	}
+	if len(nd.Links()) == 0 {
+		d.tracker.ChunkStored()
+	}
+	return nil
+}
+
+func (d *progressDag) AddMany(ctx context.Context, nds []ipld.Node) error {
+	if err := d.DAGService.AddMany(ctx, nds); err != nil {
+		return err
+	}
+	for _, nd := range nds {
+		if len(nd.Links()) == 0 {
+			d.tracker.ChunkStored()
+		}
+	}
+	return nil
+}
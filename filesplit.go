@@ -0,0 +1,118 @@
+package filehelper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs"
+	"golang.org/x/xerrors"
+)
+
+// SplitRecord ties a file imported as slices to the ordered root cids of its slices,
+// it is all that is needed to put the original file back together.
+type SplitRecord struct {
+	Path   string         `json:"path"`
+	Name   string         `json:"name"`
+	Size   int64          `json:"size"`
+	SHA256 string         `json:"sha256"`
+	Slices []*SliceRecord `json:"slices"`
+}
+
+// SliceRecord is one slice of a split file, Start and End are inclusive
+// offsets with the same meaning as Finfo.SeekStart and Finfo.SeekEnd.
+type SliceRecord struct {
+	CID   string `json:"cid"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Size  int64  `json:"size"`
+}
+
+// SplitFinfo cuts item into slices of sliceSize bytes, the last one may be shorter.
+// Building each slice with BuildFileNode gives the cids recorded by BuildSplitFile.
+// sliceSize must be at least 2: a SeekEnd of 0 means the end of the file,
+// so a first slice of a single byte could not be told from the whole file.
+func SplitFinfo(item Finfo, sliceSize int64) ([]Finfo, error) {
+	if sliceSize < 2 {
+		return nil, xerrors.Errorf("invalid slice size: %d", sliceSize)
+	}
+	size := item.Info.Size()
+	res := make([]Finfo, 0)
+	for start := int64(0); start < size; start += sliceSize {
+		end := start + sliceSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		res = append(res, Finfo{
+			Path:      item.Path,
+			Name:      item.Name,
+			Info:      item.Info,
+			SeekStart: start,
+			SeekEnd:   end,
+		})
+	}
+	return res, nil
+}
+
+// BuildSplitFile imports item as slices of sliceSize bytes in a single pass over the file
+// and returns the record of the slices together with the sha256 of the whole file.
+func BuildSplitFile(item Finfo, sliceSize int64, bufDs ipld.DAGService, cidBuilder cid.Builder) (*SplitRecord, error) {
+	slices, err := SplitFinfo(item, sliceSize)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(item.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	r := io.TeeReader(f, h)
+	rec := &SplitRecord{
+		Path:   item.Path,
+		Name:   item.Name,
+		Size:   item.Info.Size(),
+		Slices: make([]*SliceRecord, 0),
+	}
+	for _, slice := range slices {
+		size := slice.SeekEnd - slice.SeekStart + 1
+		node, err := BalanceNode(io.LimitReader(r, size), bufDs, cidBuilder)
+		if err != nil {
+			return nil, err
+		}
+		fsn, err := unixfs.ExtractFSNode(node)
+		if err != nil {
+			return nil, err
+		}
+		if fsn.FileSize() != uint64(size) {
+			return nil, xerrors.Errorf("read %d bytes for slice at %d of %s, expected %d", fsn.FileSize(), slice.SeekStart, item.Path, size)
+		}
+		rec.Slices = append(rec.Slices, &SliceRecord{
+			CID:   node.Cid().String(),
+			Start: slice.SeekStart,
+			End:   slice.SeekEnd,
+			Size:  size,
+		})
+	}
+	rec.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return rec, nil
+}
+
+// Verify checks the slices cover the file from start to end without gaps.
+func (rec *SplitRecord) Verify() error {
+	var next int64
+	for i, s := range rec.Slices {
+		if s.Start != next || s.End-s.Start+1 != s.Size {
+			return xerrors.Errorf("slice %d of %s does not follow the previous one", i, rec.Path)
+		}
+		next = s.End + 1
+	}
+	if next != rec.Size {
+		return xerrors.Errorf("slices of %s cover %d bytes, file size is %d", rec.Path, next, rec.Size)
+	}
+	return nil
+}
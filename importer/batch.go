@@ -0,0 +1,195 @@
+package importer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// WithBatchWrite stores leaves with AddMany in batches of up to maxBlocks blocks
+// or maxBytes bytes of encoded leaves, whichever is reached first. A batch is
+// also written every interval, and what is left is written when the last
+// worker is done. Leaves count as stored, for checkpoints and progress, once
+// their batch is written.
+// Encoded leaves waiting in a batch are charged to the memory budget, the batch
+// is written at once when a leaf exhausts the budget or does not fit in it.
+func WithBatchWrite(maxBlocks, maxBytes int, interval time.Duration) Option {
+	return func(o *options) {
+		o.batch = &batchConfig{
+			maxBlocks: maxBlocks,
+			maxBytes:  maxBytes,
+			interval:  interval,
+		}
+	}
+}
+
+type batchConfig struct {
+	maxBlocks int
+	maxBytes  int
+	interval  time.Duration
+}
+
+// leafBatch is a batch of leaves taken for writing, charged of them
+// hold a part of the memory budget.
+type leafBatch struct {
+	nodes   []format.Node
+	links   []IdxLink
+	charged int
+}
+
+// leafWriter stores the leaves built by the workers of a pipeline,
+// one by one or in batches, and hands their links over once stored.
+type leafWriter struct {
+	ds     format.DAGService
+	o      *options
+	p      *pipeline
+	budget *MemoryBudget
+	active int32
+	// closed once the last worker is done
+	done chan struct{}
+
+	lk      sync.Mutex
+	nodes   []format.Node
+	links   []IdxLink
+	size    int
+	charged int
+}
+
+// newLeafWriter must be called before the pipeline collects, it spawns
+// the goroutine writing batches every interval.
+func newLeafWriter(p *pipeline, ds format.DAGService, o *options, workers int, budget *MemoryBudget) *leafWriter {
+	w := &leafWriter{
+		ds:     ds,
+		o:      o,
+		p:      p,
+		budget: budget,
+		active: int32(workers),
+		done:   make(chan struct{}),
+	}
+	if o.batch != nil && o.batch.interval > 0 {
+		p.spawn(w.flushEvery)
+	}
+	return w
+}
+
+// flushEvery writes the pending batch every interval until the workers are done.
+func (w *leafWriter) flushEvery() {
+	t := time.NewTicker(w.o.batch.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.lk.Lock()
+			b := w.take()
+			w.lk.Unlock()
+			if err := w.write(b); err != nil {
+				w.p.fail(err)
+				return
+			}
+		case <-w.done:
+			return
+		case <-w.p.ctx.Done():
+			return
+		}
+	}
+}
+
+// put stores nd, whose link is lk, or adds it to the current batch.
+func (w *leafWriter) put(nd format.Node, lk IdxLink) error {
+	if w.o.checkpoint.recorded(lk) {
+		// stored by the import the checkpoint comes from
+		w.p.send(lk)
+		return nil
+	}
+	if w.o.batch == nil {
+		if err := w.ds.Add(w.p.ctx, nd); err != nil {
+			return newChunkError(lk.Idx, err)
+		}
+		return w.stored([]IdxLink{lk})
+	}
+	cfg := w.o.batch
+	charged := w.budget.tryAcquire()
+	w.lk.Lock()
+	w.nodes = append(w.nodes, nd)
+	w.links = append(w.links, lk)
+	w.size += len(nd.RawData())
+	if charged {
+		w.charged++
+	}
+	var b *leafBatch
+	// waiting for more leaves once the budget is exhausted would starve the readers
+	if !charged || w.budget.exhausted() ||
+		(cfg.maxBlocks > 0 && len(w.nodes) >= cfg.maxBlocks) ||
+		(cfg.maxBytes > 0 && w.size >= cfg.maxBytes) {
+		b = w.take()
+	}
+	w.lk.Unlock()
+	return w.write(b)
+}
+
+// workerDone is called by every worker on exit, the last one writes
+// the pending batch unless the pipeline has failed.
+func (w *leafWriter) workerDone() {
+	if atomic.AddInt32(&w.active, -1) != 0 {
+		return
+	}
+	defer close(w.done)
+	w.lk.Lock()
+	b := w.take()
+	w.lk.Unlock()
+	if w.p.ctx.Err() != nil {
+		w.release(b)
+		return
+	}
+	if err := w.write(b); err != nil {
+		w.p.fail(err)
+	}
+}
+
+// take empties the current batch, w.lk must be held.
+func (w *leafWriter) take() *leafBatch {
+	if len(w.nodes) == 0 {
+		return nil
+	}
+	b := &leafBatch{nodes: w.nodes, links: w.links, charged: w.charged}
+	w.nodes, w.links, w.size, w.charged = nil, nil, 0, 0
+	return b
+}
+
+func (w *leafWriter) release(b *leafBatch) {
+	if b == nil {
+		return
+	}
+	for i := 0; i < b.charged; i++ {
+		w.budget.release()
+	}
+}
+
+func (w *leafWriter) write(b *leafBatch) error {
+	if b == nil {
+		return nil
+	}
+	err := w.ds.AddMany(w.p.ctx, b.nodes)
+	w.release(b)
+	if err != nil {
+		return newChunkError(b.links[0].Idx, xerrors.Errorf("write batch of %d leaves: %w", len(b.nodes), err))
+	}
+	return w.stored(b.links)
+}
+
+// stored records stored leaves and sends their links to the collector.
+func (w *leafWriter) stored(links []IdxLink) error {
+	for _, lk := range links {
+		if err := w.o.checkpoint.record(lk); err != nil {
+			return xerrors.Errorf("record checkpoint: %w", err)
+		}
+		w.o.tracker.ChunkStored()
+		if !w.p.send(lk) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package importer_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filedrive-team/filehelper/importer"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// batchDAG counts the batches stored with AddMany.
+type batchDAG struct {
+	discardDAG
+	batches int64
+}
+
+func (d *batchDAG) AddMany(ctx context.Context, nds []format.Node) error {
+	atomic.AddInt64(&d.batches, 1)
+	return nil
+}
+
+// slowReader waits delay before every read.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p)
+}
+
+func (r *slowReader) ReadAt(p []byte, off int64) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.(io.ReaderAt).ReadAt(p, off)
+}
+
+func TestBatchWriteInterval(t *testing.T) {
+	data, _ := testData(t, 0)
+	ctx := context.Background()
+	run := map[string]func(r *slowReader, ds format.DAGService, opts ...importer.Option) error{
+		"BalanceNode": func(r *slowReader, ds format.DAGService, opts ...importer.Option) error {
+			_, err := importer.BalanceNode(ctx, r, int64(len(data)), ds, builder(), 4, opts...)
+			return err
+		},
+		"BalanceNodeAt": func(r *slowReader, ds format.DAGService, opts ...importer.Option) error {
+			_, err := importer.BalanceNodeAt(ctx, r, int64(len(data)), ds, builder(), 4, opts...)
+			return err
+		},
+	}
+	for name, imp := range run {
+		// the batch never fills, only the interval writes it before the end
+		dag := &batchDAG{}
+		r := &slowReader{r: bytes.NewReader(data), delay: 10 * time.Millisecond}
+		if err := imp(r, dag, importer.WithBatchWrite(1000, 0, 20*time.Millisecond)); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if n := atomic.LoadInt64(&dag.batches); n < 2 {
+			t.Fatalf("%s: %d batch written, expected the interval to write more", name, n)
+		}
+	}
+}
+
+func TestBatchWriteBudget(t *testing.T) {
+	data, _ := testData(t, 0)
+	importer.SetMemoryBudget(2 * chunk)
+	defer importer.SetMemoryBudget(0)
+	for _, imp := range importers {
+		// buffered leaves hold the whole budget long before the batch is full
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := imp.run(ctx, data, &batchDAG{}, importer.WithBatchWrite(1000, 0, 0))
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: %s", imp.name, err)
+		}
+	}
+}
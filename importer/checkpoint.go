@@ -0,0 +1,287 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"golang.org/x/xerrors"
+)
+
+var checkpointMagic = []byte("FHCKPT01")
+
+// checkpoint is an append only log of the chunks stored by an import.
+// The header records the chunk size, the cid prefix and the file size, every
+// entry holds the index, size and leaf cid of a stored chunk. A torn entry at
+// the end, left by a crash, is dropped when the log is loaded.
+type checkpoint struct {
+	path     string
+	interval time.Duration
+	bs       blockstore.Blockstore
+
+	lk        sync.Mutex
+	f         *os.File
+	w         *bufio.Writer
+	lastFlush time.Time
+	header    []byte
+	done      map[int]*linkAndSize
+}
+
+// WithCheckpoint makes the import resumable. Stored chunks are recorded in the
+// sidecar file at path, synced to disk every interval. When the file exists and
+// matches the import, the recorded chunks whose leaves are still present are
+// reused: BalanceNode continues from the first missing chunk, seeking past the
+// bytes before it when f is an io.Seeker and reading them otherwise, and
+// BalanceNodeAt skips every recorded chunk. The source is assumed unchanged
+// since the checkpoint, see WithCheckpointVerify.
+// Leaves are looked up in bs, or fetched from the dag service if bs is nil.
+// The file is removed once the import succeeds.
+func WithCheckpoint(path string, interval time.Duration, bs blockstore.Blockstore) Option {
+	return func(o *options) {
+		o.checkpoint = &checkpoint{
+			path:     path,
+			interval: interval,
+			bs:       bs,
+		}
+	}
+}
+
+// WithCheckpointVerify makes an import WithCheckpoint read every chunk again
+// and build its leaf, a recorded chunk is only reused when its leaf cid is the
+// recorded one. Only the stores are saved, for sources which may have changed
+// since the checkpoint.
+func WithCheckpointVerify() Option {
+	return func(o *options) {
+		o.verifyCheckpoint = true
+	}
+}
+
+func checkpointHeader(fsize int64, cidBuilder cid.Builder) ([]byte, error) {
+	if cidBuilder == nil {
+		cidBuilder = merkledag.V0CidPrefix()
+	}
+	c, err := cidBuilder.Sum(nil)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(checkpointMagic)
+	writeUvarint(&buf, UnixfsChunkSize)
+	pfx := c.Prefix().Bytes()
+	writeUvarint(&buf, uint64(len(pfx)))
+	buf.Write(pfx)
+	vb := make([]byte, binary.MaxVarintLen64)
+	buf.Write(vb[:binary.PutVarint(vb, fsize)])
+	return buf.Bytes(), nil
+}
+
+// open loads the recorded chunks if the log belongs to the same import
+// and gets the log ready for appending.
+func (cp *checkpoint) open(ctx context.Context, fsize int64, cidBuilder cid.Builder, ng format.NodeGetter) error {
+	header, err := checkpointHeader(fsize, cidBuilder)
+	if err != nil {
+		return err
+	}
+	cp.header = header
+	cp.done = make(map[int]*linkAndSize)
+
+	valid, err := cp.load()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(cp.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if valid == 0 {
+		if _, err := w.Write(header); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := cp.verify(ctx, ng); err != nil {
+		f.Close()
+		return err
+	}
+	cp.f = f
+	cp.w = w
+	cp.lastFlush = time.Now()
+	return nil
+}
+
+// load reads the existing log and returns the length of its valid part,
+// zero when there is no log or it belongs to another import.
+func (cp *checkpoint) load() (int64, error) {
+	data, err := os.ReadFile(cp.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !bytes.HasPrefix(data, cp.header) {
+		log.Warnf("checkpoint %s does not match the import, starting over", cp.path)
+		return 0, nil
+	}
+	r := bytes.NewReader(data[len(cp.header):])
+	valid := int64(len(cp.header))
+	for r.Len() > 0 {
+		idx, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		lsize, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		cl, err := binary.ReadUvarint(r)
+		if err != nil || cl > uint64(r.Len()) {
+			break
+		}
+		cb := make([]byte, cl)
+		r.Read(cb)
+		c, err := cid.Cast(cb)
+		if err != nil {
+			break
+		}
+		cp.done[int(idx)] = &linkAndSize{
+			Link:     &format.Link{Cid: c, Size: lsize},
+			FileSize: size,
+		}
+		valid = int64(len(data) - r.Len())
+	}
+	return valid, nil
+}
+
+// verify drops the recorded chunks whose leaf is not available any more.
+func (cp *checkpoint) verify(ctx context.Context, ng format.NodeGetter) error {
+	for idx, l := range cp.done {
+		if cp.bs != nil {
+			has, err := cp.bs.Has(l.Link.Cid)
+			if err != nil {
+				return err
+			}
+			if !has {
+				delete(cp.done, idx)
+			}
+			continue
+		}
+		if _, err := ng.Get(ctx, l.Link.Cid); err != nil {
+			if xerrors.Is(err, format.ErrNotFound) {
+				delete(cp.done, idx)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// prefix returns the links of the recorded chunks before the first missing one.
+func (cp *checkpoint) prefix() []*linkAndSize {
+	if cp == nil {
+		return nil
+	}
+	res := make([]*linkAndSize, 0)
+	for {
+		l, ok := cp.done[len(res)]
+		if !ok {
+			return res
+		}
+		res = append(res, l)
+	}
+}
+
+func (cp *checkpoint) get(idx int) (*linkAndSize, bool) {
+	if cp == nil {
+		return nil, false
+	}
+	l, ok := cp.done[idx]
+	return l, ok
+}
+
+// recorded reports whether lk is the leaf recorded for its chunk,
+// which is then already stored.
+func (cp *checkpoint) recorded(lk IdxLink) bool {
+	if cp == nil {
+		return false
+	}
+	l, ok := cp.done[lk.Idx]
+	return ok && l.FileSize == lk.FileSize && l.Link.Cid.Equals(lk.Link.Cid)
+}
+
+// record appends a stored chunk, the log is synced once interval has passed.
+func (cp *checkpoint) record(lk IdxLink) error {
+	if cp == nil {
+		return nil
+	}
+	cp.lk.Lock()
+	defer cp.lk.Unlock()
+	var buf bytes.Buffer
+	writeUvarint(&buf, uint64(lk.Idx))
+	writeUvarint(&buf, lk.FileSize)
+	writeUvarint(&buf, lk.Link.Size)
+	cb := lk.Link.Cid.Bytes()
+	writeUvarint(&buf, uint64(len(cb)))
+	buf.Write(cb)
+	if _, err := cp.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if time.Since(cp.lastFlush) >= cp.interval {
+		return cp.sync()
+	}
+	return nil
+}
+
+func (cp *checkpoint) sync() error {
+	if err := cp.w.Flush(); err != nil {
+		return err
+	}
+	cp.lastFlush = time.Now()
+	return cp.f.Sync()
+}
+
+// close syncs the log, or removes it when the import has succeeded.
+func (cp *checkpoint) close(success bool) error {
+	if cp == nil || cp.f == nil {
+		return nil
+	}
+	cp.lk.Lock()
+	defer cp.lk.Unlock()
+	if success {
+		cp.f.Close()
+		return os.Remove(cp.path)
+	}
+	err := cp.sync()
+	if cerr := cp.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeUvarint(w io.Writer, v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.Write(buf[:binary.PutUvarint(buf, v)])
+}
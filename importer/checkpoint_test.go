@@ -0,0 +1,165 @@
+package importer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filedrive-team/filehelper/importer"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// countingDAG stores into a dag service, counting the blocks added,
+// and fails on the block fail.
+type countingDAG struct {
+	format.DAGService
+	fail  cid.Cid
+	added int64
+}
+
+func (d *countingDAG) Add(ctx context.Context, nd format.Node) error {
+	return d.AddMany(ctx, []format.Node{nd})
+}
+
+func (d *countingDAG) AddMany(ctx context.Context, nds []format.Node) error {
+	for _, nd := range nds {
+		if nd.Cid().Equals(d.fail) {
+			return errStore
+		}
+	}
+	atomic.AddInt64(&d.added, int64(len(nds)))
+	return d.DAGService.AddMany(ctx, nds)
+}
+
+// countingReader counts the bytes read below limit.
+type countingReader struct {
+	r     *bytes.Reader
+	limit int64
+	below int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	pos := r.r.Size() - int64(r.r.Len())
+	n, err := r.r.Read(p)
+	r.count(pos, n)
+	return n, err
+}
+
+func (r *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.count(off, n)
+	return n, err
+}
+
+func (r *countingReader) Seek(offset int64, whence int) (int64, error) {
+	return r.r.Seek(offset, whence)
+}
+
+func (r *countingReader) count(off int64, n int) {
+	if off < r.limit {
+		end := off + int64(n)
+		if end > r.limit {
+			end = r.limit
+		}
+		atomic.AddInt64(&r.below, end-off)
+	}
+}
+
+// noSeek hides the Seek method of a reader.
+type noSeek struct {
+	io.Reader
+}
+
+type resumeFunc func(ctx context.Context, r *countingReader, ds format.DAGService, opts ...importer.Option) (cid.Cid, error)
+
+// a single worker stores the chunks in order, all the chunks before
+// the failing one are recorded
+var resumers = []struct {
+	name string
+	run  resumeFunc
+	// whether the recorded prefix is read through to skip it
+	reads bool
+}{
+	{"BalanceNode", func(ctx context.Context, r *countingReader, ds format.DAGService, opts ...importer.Option) (cid.Cid, error) {
+		return importer.BalanceNode(ctx, r, r.r.Size(), ds, builder(), 1, opts...)
+	}, false},
+	{"BalanceNode without seek", func(ctx context.Context, r *countingReader, ds format.DAGService, opts ...importer.Option) (cid.Cid, error) {
+		return importer.BalanceNode(ctx, noSeek{r}, r.r.Size(), ds, builder(), 1, opts...)
+	}, true},
+	{"BalanceNodeAt", func(ctx context.Context, r *countingReader, ds format.DAGService, opts ...importer.Option) (cid.Cid, error) {
+		return importer.BalanceNodeAt(ctx, r, r.r.Size(), ds, builder(), 1, opts...)
+	}, false},
+}
+
+func TestCheckpointResume(t *testing.T) {
+	ctx := context.Background()
+	const failIdx = 30
+	for _, imp := range resumers {
+		for _, verify := range []bool{false, true} {
+			data, fail := testData(t, failIdx)
+			bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+			dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+			cpPath := filepath.Join(t.TempDir(), "checkpoint")
+			opts := []importer.Option{importer.WithCheckpoint(cpPath, time.Hour, bs)}
+			if verify {
+				opts = append(opts, importer.WithCheckpointVerify())
+			}
+
+			src := &countingReader{r: bytes.NewReader(data)}
+			_, err := imp.run(ctx, src, &countingDAG{DAGService: dag, fail: fail}, opts...)
+			if !errors.Is(err, errStore) {
+				t.Fatalf("%s: expected the store failure, got %v", imp.name, err)
+			}
+			if _, err := os.Stat(cpPath); err != nil {
+				t.Fatalf("%s: checkpoint not kept: %s", imp.name, err)
+			}
+			if verify {
+				// the source changes under a recorded chunk, only found by verifying
+				data = append([]byte(nil), data...)
+				data[5*chunk+7] ^= 0xff
+			}
+
+			resumed := &countingDAG{DAGService: dag}
+			src = &countingReader{r: bytes.NewReader(data), limit: failIdx * chunk}
+			got, err := imp.run(ctx, src, resumed, opts...)
+			if err != nil {
+				t.Fatalf("%s verify %v: resume: %s", imp.name, verify, err)
+			}
+			want, err := importer.BalanceNode(ctx, bytes.NewReader(data), int64(len(data)), discardDAG{}, builder(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equals(want) {
+				t.Fatalf("%s verify %v: resumed root %s, expected %s", imp.name, verify, got, want)
+			}
+			below := atomic.LoadInt64(&src.below)
+			switch {
+			case verify && below != failIdx*chunk:
+				t.Fatalf("%s: verifying read %d bytes of the recorded chunks, expected all of them", imp.name, below)
+			case !verify && !imp.reads && below != 0:
+				t.Fatalf("%s: %d bytes of the recorded chunks read on resume", imp.name, below)
+			}
+			// leaves of recorded chunks are not stored again, but the changed one
+			stored := atomic.LoadInt64(&resumed.added)
+			if max := int64(40 - failIdx + 2); stored > max {
+				t.Fatalf("%s verify %v: %d blocks stored on resume, expected at most %d", imp.name, verify, stored, max)
+			}
+			if _, err := os.Stat(cpPath); !os.IsNotExist(err) {
+				t.Fatalf("%s: checkpoint not removed: %v", imp.name, err)
+			}
+		}
+	}
+}
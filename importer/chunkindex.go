@@ -0,0 +1,176 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+var chunkIndexMagic = []byte("FHCIDX01")
+
+// ChunkEntry locates the leaf holding one chunk of a file.
+type ChunkEntry struct {
+	Idx    int
+	Offset uint64
+	Length uint64
+	Cid    cid.Cid
+}
+
+// ChunkIndex maps the byte ranges of an imported file to its leaves,
+// so partial retrievals can pick the leaves they need without walking the dag.
+type ChunkIndex struct {
+	Root    cid.Cid
+	Size    uint64
+	Entries []ChunkEntry
+}
+
+// WithChunkIndex fills ci with the leaves of the file once the import succeeds.
+func WithChunkIndex(ci *ChunkIndex) Option {
+	return func(o *options) {
+		o.index = ci
+	}
+}
+
+func (ci *ChunkIndex) fill(root cid.Cid, links []*linkAndSize) {
+	if ci == nil {
+		return
+	}
+	ci.Root = root
+	ci.Size = 0
+	ci.Entries = make([]ChunkEntry, 0, len(links))
+	for i, l := range links {
+		ci.Entries = append(ci.Entries, ChunkEntry{
+			Idx:    i,
+			Offset: ci.Size,
+			Length: l.FileSize,
+			Cid:    l.Link.Cid,
+		})
+		ci.Size += l.FileSize
+	}
+}
+
+// Lookup returns the entries of the leaves covering length bytes from offset.
+func (ci *ChunkIndex) Lookup(offset, length uint64) []ChunkEntry {
+	if length == 0 || offset >= ci.Size {
+		return nil
+	}
+	end := offset + length
+	first := sort.Search(len(ci.Entries), func(i int) bool {
+		e := ci.Entries[i]
+		return e.Offset+e.Length > offset
+	})
+	last := first
+	for last < len(ci.Entries) && ci.Entries[last].Offset < end {
+		last++
+	}
+	return ci.Entries[first:last]
+}
+
+// WriteTo writes the index in its compact binary form: a magic, the root cid
+// and the number of entries, then the length and cid of every leaf.
+// Indices and offsets follow from the order of the entries.
+func (ci *ChunkIndex) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	cw.Write(chunkIndexMagic)
+	writeBytes(cw, ci.Root.Bytes())
+	writeUvarint(cw, uint64(len(ci.Entries)))
+	for _, e := range ci.Entries {
+		writeUvarint(cw, e.Length)
+		writeBytes(cw, e.Cid.Bytes())
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// ReadChunkIndex reads an index written by ChunkIndex.WriteTo.
+func ReadChunkIndex(r io.Reader) (*ChunkIndex, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(chunkIndexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != string(chunkIndexMagic) {
+		return nil, xerrors.New("not a chunk index")
+	}
+	rb, err := readBytes(br)
+	if err != nil {
+		return nil, err
+	}
+	root, err := cid.Cast(rb)
+	if err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	ci := &ChunkIndex{
+		Root:    root,
+		Entries: make([]ChunkEntry, 0, n),
+	}
+	for i := 0; i < int(n); i++ {
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, xerrors.Errorf("entry %d: %w", i, err)
+		}
+		cb, err := readBytes(br)
+		if err != nil {
+			return nil, xerrors.Errorf("entry %d: %w", i, err)
+		}
+		c, err := cid.Cast(cb)
+		if err != nil {
+			return nil, xerrors.Errorf("entry %d: %w", i, err)
+		}
+		ci.Entries = append(ci.Entries, ChunkEntry{
+			Idx:    i,
+			Offset: ci.Size,
+			Length: length,
+			Cid:    c,
+		})
+		ci.Size += length
+	}
+	return ci, nil
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+func writeBytes(w io.Writer, b []byte) {
+	writeUvarint(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func readBytes(br *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if l > 1<<10 {
+		return nil, xerrors.Errorf("invalid cid length: %d", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
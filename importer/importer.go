@@ -3,8 +3,8 @@ package importer
 import (
 	"context"
 	"io"
+	"io/ioutil"
 	"math"
-	"sync"
 
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
@@ -88,16 +88,15 @@ func (n *FSNodeOverDag) Commit() (format.Node, error) {
 	return n.dag, nil
 }
 
-func buildCidByLinks(ctx context.Context, links []*linkAndSize, dagServ format.DAGService) (cid.Cid, error) {
+// buildCidByLinks builds the parent levels over the leaf links bottom up,
+// grouping maxLinkNum children per node. Every level but the last one is made
+// of full nodes, which is the same tree go-unixfs balanced.Layout produces
+// for the same leaves, so both yield the same root cid for a given cidBuilder.
+func buildCidByLinks(ctx context.Context, links []*linkAndSize, dagServ format.DAGService, cidBuilder cid.Builder) (cid.Cid, error) {
 	maxLinkNum := UnixfsLinksPerLevel
 	var linkList = make([]*linkAndSize, 0)
 	var needAdd = make([]format.Node, 0)
 
-	// cidbuilder
-	cidBuilder, err := merkledag.PrefixForCidVersion(0)
-	if err != nil {
-		return cid.Undef, err
-	}
 	for len(links) > 1 {
 		var nd *merkledag.ProtoNode = unixfs.EmptyFileNode()
 		nd.SetCidBuilder(cidBuilder)
@@ -165,78 +164,115 @@ func buildCidByLinks(ctx context.Context, links []*linkAndSize, dagServ format.D
 	return links[0].Link.Cid, nil
 }
 
-// Todos:
-//  read more bytes and parallel the dags save work
-func BalanceNode(ctx context.Context, f io.Reader, fsize int64, bufDs format.DAGService, cidBuilder cid.Builder, batchReadNum int) (cid.Cid, error) {
-	if fsize == 0 {
-		return cid.Undef, xerrors.Errorf("file size should not be zero")
+// BalanceNode imports f the same way as filehelper.BuildFileNode does, with
+// batchReadNum workers building and storing leaves in parallel.
+// The resulting dag and root cid are identical to the ones of go-unixfs balanced.Layout
+// with the same cidBuilder, chunk size and links per level.
+// fsize is only used to size the link table up front, pass -1 when the length
+// of f is unknown (pipes, stdin, network streams). An empty f gives the empty file node.
+// Chunks are read into pooled buffers charged to the budget set by SetMemoryBudget,
+// reading pauses while the budget or the workers are exhausted.
+// The first failing chunk, reported as a *ChunkError, or the cancellation of ctx
+// stops all workers; BalanceNode returns once they have exited, which includes
+// waiting for a Read on f in progress.
+// See WithProgress for reporting the progress of the import, WithCheckpoint
+// for making it resumable, WithBatchWrite for storing leaves in batches and
+// WithChunkIndex for getting the byte range of every leaf.
+func BalanceNode(ctx context.Context, f io.Reader, fsize int64, bufDs format.DAGService, cidBuilder cid.Builder, batchReadNum int, opts ...Option) (root cid.Cid, err error) {
+	if batchReadNum < 1 {
+		batchReadNum = 1
 	}
+	o := buildOptions(opts)
+	defer func() {
+		o.tracker.Finish(root, err)
+	}()
+	budget := currentBudget()
 	cker := NewBatchSplitter(f, int64(UnixfsChunkSize), batchReadNum)
-	dataLinks := make([]*linkAndSize, dataLinkNum(fsize, int64(UnixfsChunkSize)))
-	errchan := make(chan error)
-	finishedchan := make(chan struct{})
-	linkchan := make(chan IdxLink)
-	go func(linkchan chan IdxLink, finishedchan chan struct{}, errchan chan error) {
-		for {
-			select {
-			case <-ctx.Done():
-				errchan <- ctx.Err()
-				return
-			default:
+	dataLinks := make([]*linkAndSize, 0, dataLinkNum(fsize, int64(UnixfsChunkSize)))
+	if cp := o.checkpoint; cp != nil {
+		if err := cp.open(ctx, fsize, cidBuilder, bufDs); err != nil {
+			return cid.Undef, err
+		}
+		defer func() {
+			if cerr := cp.close(err == nil); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		if !o.verifyCheckpoint {
+			// continue from the first missing chunk
+			dataLinks = append(dataLinks, cp.prefix()...)
+			var skip int64
+			for _, l := range dataLinks {
+				skip += int64(l.FileSize)
+			}
+			if err := skipBytes(f, skip); err != nil {
+				return cid.Undef, xerrors.Errorf("resume from checkpoint: %w", err)
+			}
+			cker.lastidx = len(dataLinks)
+			o.tracker.Read(int(skip))
+		}
+	}
+	p := newPipeline(ctx)
+	workchan := make(chan *Idxbuf)
+	lw := newLeafWriter(p, bufDs, o, batchReadNum, budget)
+
+	for i := 0; i < batchReadNum; i++ {
+		p.spawn(func() {
+			defer lw.workerDone()
+			for ib := range workchan {
+				if p.ctx.Err() != nil {
+					releaseChunk(ib, budget)
+					continue
+				}
+				//fmt.Printf("id: %d, size: %d\n", ib.Idx, len(ib.Buf))
+				nd, lk, err := buildLeaf(ib, cidBuilder, budget)
+				if err != nil {
+					p.fail(newChunkError(ib.Idx, err))
+					continue
+				}
+				if err := lw.put(nd, lk); err != nil {
+					p.fail(err)
+				}
 			}
-			rd, err := cker.NextBytes()
-			if err == io.EOF {
-				finishedchan <- struct{}{}
+		})
+	}
+	p.spawn(func() {
+		defer close(workchan)
+		for {
+			if err := budget.acquire(p.ctx); err != nil {
 				return
 			}
+			buf := getChunkBuf()
+			ib, err := cker.NextChunk(*buf)
 			if err != nil {
-				errchan <- err
+				putChunkBuf(buf)
+				budget.release()
+				if err != io.EOF {
+					p.fail(newChunkError(cker.lastidx, err))
+				}
 				return
 			}
-			wg := sync.WaitGroup{}
-			wg.Add(len(rd))
-			for _, idxbuf := range rd {
-				go func(ib *Idxbuf) {
-					defer wg.Done()
-					//fmt.Printf("id: %d, size: %d\n", ib.Idx, len(ib.Buf))
-					dag, err := NewDagWithData(ib.Buf, pb.Data_File, cidBuilder)
-					if err != nil {
-						errchan <- err
-						return
-					}
-					if err = bufDs.Add(ctx, dag); err != nil {
-						errchan <- err
-						return
-					}
-					link, err := format.MakeLink(dag)
-					if err != nil {
-						errchan <- err
-						return
-					}
-					linkchan <- IdxLink{
-						Idx:      ib.Idx,
-						Link:     link,
-						FileSize: uint64(len(ib.Buf)),
-					}
-				}(idxbuf)
+			ib.pooled = buf
+			o.tracker.Read(len(ib.Buf))
+			select {
+			case workchan <- ib:
+			case <-p.ctx.Done():
+				releaseChunk(ib, budget)
+				return
 			}
-			wg.Wait()
 		}
-
-	}(linkchan, finishedchan, errchan)
-lab:
-	for {
-		select {
-		case err := <-errchan:
-			return cid.Undef, err
-		case <-finishedchan:
-			break lab
-		case lk := <-linkchan:
-			dataLinks[lk.Idx] = &linkAndSize{
-				Link:     lk.Link,
-				FileSize: lk.FileSize,
-			}
+	})
+	if err := p.collect(func(lk IdxLink) {
+		// the link table grows as chunks arrive, fsize is only a hint
+		for lk.Idx >= len(dataLinks) {
+			dataLinks = append(dataLinks, nil)
+		}
+		dataLinks[lk.Idx] = &linkAndSize{
+			Link:     lk.Link,
+			FileSize: lk.FileSize,
 		}
+	}); err != nil {
+		return cid.Undef, err
 	}
 	for _, l := range dataLinks {
 		if l == nil {
@@ -245,15 +281,68 @@ lab:
 		//log.Infof("index: %d, bytes len: %d", i, l.FileSize)
 
 	}
-	ciid, err := buildCidByLinks(ctx, dataLinks, bufDs)
+	if len(dataLinks) == 0 {
+		root, err = emptyFileNode(ctx, bufDs, cidBuilder)
+		if err == nil {
+			o.index.fill(root, nil)
+		}
+		return root, err
+	}
+	ciid, err := buildCidByLinks(ctx, dataLinks, bufDs, cidBuilder)
 	if err != nil {
 		return cid.Undef, err
 	}
+	o.index.fill(ciid, dataLinks)
 	return ciid, nil
 }
 
+// skipBytes moves f forward by n bytes, seeking when f supports it.
+func skipBytes(f io.Reader, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := f.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, f, n)
+	return err
+}
+
+// buildLeaf builds the leaf of ib, its buffer goes back to the pool
+// as soon as the node is encoded.
+func buildLeaf(ib *Idxbuf, cidBuilder cid.Builder, budget *MemoryBudget) (format.Node, IdxLink, error) {
+	size := uint64(len(ib.Buf))
+	dag, err := NewDagWithData(ib.Buf, pb.Data_File, cidBuilder)
+	releaseChunk(ib, budget)
+	if err != nil {
+		return nil, IdxLink{}, err
+	}
+	link, err := format.MakeLink(dag)
+	if err != nil {
+		return nil, IdxLink{}, err
+	}
+	return dag, IdxLink{
+		Idx:      ib.Idx,
+		Link:     link,
+		FileSize: size,
+	}, nil
+}
+
+// emptyFileNode stores the leaf go-unixfs builds for an empty file.
+func emptyFileNode(ctx context.Context, bufDs format.DAGService, cidBuilder cid.Builder) (cid.Cid, error) {
+	nd, err := NewDagWithData(nil, pb.Data_File, cidBuilder)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bufDs.Add(ctx, nd); err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
 func dataLinkNum(size, chunksize int64) int {
-	if size == 0 || chunksize == 0 {
+	if size <= 0 || chunksize <= 0 {
 		return 0
 	}
 	r := float64(size) / float64(chunksize)
@@ -269,6 +358,8 @@ type IdxLink struct {
 type Idxbuf struct {
 	Idx int
 	Buf []byte
+	// pool buffer backing Buf, if any
+	pooled *[]byte
 }
 
 type BatchSplitter struct {
@@ -288,6 +379,8 @@ func NewBatchSplitter(r io.Reader, size int64, batch int) *BatchSplitter {
 	}
 }
 
+// NextBytes reads up to batch chunks into buffers owned by the caller,
+// unlike NextChunk they are not taken from the chunk pool.
 func (ss *BatchSplitter) NextBytes() ([]*Idxbuf, error) {
 	if ss.err != nil {
 		return nil, ss.err
@@ -307,6 +400,28 @@ func (ss *BatchSplitter) NextBytes() ([]*Idxbuf, error) {
 	}
 }
 
+// NextChunk reads the next chunk into buf, which must hold at least one chunk.
+// The returned Idxbuf is a slice of buf, valid as long as buf is not reused.
+func (ss *BatchSplitter) NextChunk(buf []byte) (*Idxbuf, error) {
+	if ss.err != nil {
+		return nil, ss.err
+	}
+	n, err := io.ReadFull(ss.r, buf[:ss.size])
+	switch err {
+	case io.ErrUnexpectedEOF:
+		ss.err = io.EOF
+	case nil:
+	default:
+		return nil, err
+	}
+	ib := &Idxbuf{
+		Idx: ss.lastidx,
+		Buf: buf[:n],
+	}
+	ss.lastidx++
+	return ib, nil
+}
+
 func (ss *BatchSplitter) indexedbuf(buf []byte) ([]*Idxbuf, error) {
 	res := make([]*Idxbuf, 0)
 	for {
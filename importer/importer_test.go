@@ -0,0 +1,222 @@
+package importer_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/filedrive-team/filehelper/importer"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+const chunk = int64(importer.UnixfsChunkSize)
+
+// discardDAG stores nothing, the builders never read back what they add.
+type discardDAG struct{}
+
+func (discardDAG) Get(context.Context, cid.Cid) (format.Node, error) {
+	return nil, format.ErrNotFound
+}
+
+func (discardDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	for range cids {
+		out <- &format.NodeOption{Err: format.ErrNotFound}
+	}
+	close(out)
+	return out
+}
+
+func (discardDAG) Add(context.Context, format.Node) error       { return nil }
+func (discardDAG) AddMany(context.Context, []format.Node) error { return nil }
+func (discardDAG) Remove(context.Context, cid.Cid) error        { return nil }
+func (discardDAG) RemoveMany(context.Context, []cid.Cid) error  { return nil }
+
+// writeFile creates a file of size bytes, random below 64 MiB and sparse above
+// so that the trees with many leaves stay cheap to build.
+func writeFile(t *testing.T, size int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if size <= 64<<20 {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(size)).Read(data)
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBalanceNodeEquivalence(t *testing.T) {
+	ctx := context.Background()
+	v0, err := merkledag.PrefixForCidVersion(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builders := []struct {
+		name    string
+		builder cid.Builder
+	}{
+		{"cidv0", v0},
+		{"cidv1", v1},
+	}
+	cases := []struct {
+		name string
+		size int64
+		long bool
+	}{
+		{"empty", 0, false},
+		{"one byte", 1, false},
+		{"chunk-1", chunk - 1, false},
+		{"chunk", chunk, false},
+		{"chunk+1", chunk + 1, false},
+		// go-unixfs default links per block
+		{"174 chunks", 174 * chunk, false},
+		{"175 chunks", 175 * chunk, false},
+		// links per level of this importer, a second level of parents above
+		{"1024 chunks", importer.UnixfsLinksPerLevel * chunk, true},
+		{"1024 chunks+1", importer.UnixfsLinksPerLevel*chunk + 1, true},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.long && testing.Short() {
+				t.Skip("large file")
+			}
+			path := writeFile(t, tc.size)
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range builders {
+				nd, err := filehelper.BuildFileNode(filehelper.Finfo{Path: path, Name: fi.Name(), Info: fi}, discardDAG{}, b.builder)
+				if err != nil {
+					t.Fatalf("%s: BuildFileNode: %s", b.name, err)
+				}
+				want := nd.Cid()
+
+				f, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := importer.BalanceNode(ctx, f, tc.size, discardDAG{}, b.builder, 4)
+				f.Close()
+				if err != nil {
+					t.Fatalf("%s: BalanceNode: %s", b.name, err)
+				}
+				if !got.Equals(want) {
+					t.Fatalf("%s: BalanceNode gives %s, BuildFileNode %s", b.name, got, want)
+				}
+
+				f, err = os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err = importer.BalanceNodeAt(ctx, f, tc.size, discardDAG{}, b.builder, 4)
+				f.Close()
+				if err != nil {
+					t.Fatalf("%s: BalanceNodeAt: %s", b.name, err)
+				}
+				if !got.Equals(want) {
+					t.Fatalf("%s: BalanceNodeAt gives %s, BuildFileNode %s", b.name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// pipeData streams data through a pipe in writes of odd sizes,
+// so that the importer sees short reads and no length.
+func pipeData(data []byte) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		for len(data) > 0 {
+			n := 4093
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := pw.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+func TestBalanceNodeUnknownSize(t *testing.T) {
+	ctx := context.Background()
+	for _, size := range []int64{0, 1, chunk - 1, chunk, chunk + 1, 5*chunk + 3} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(size)).Read(data)
+		want, err := importer.BalanceNode(ctx, bytes.NewReader(data), size, discardDAG{}, builder(), 4)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		// no size, and a size hint short of the data
+		for _, hint := range []int64{-1, size / 2} {
+			got, err := importer.BalanceNode(ctx, pipeData(data), hint, discardDAG{}, builder(), 4)
+			if err != nil {
+				t.Fatalf("size %d hint %d: %s", size, hint, err)
+			}
+			if !got.Equals(want) {
+				t.Fatalf("size %d hint %d: root %s, expected %s", size, hint, got, want)
+			}
+		}
+	}
+}
+
+func TestNextBytesOwned(t *testing.T) {
+	data := make([]byte, 5*1024+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	ss := importer.NewBatchSplitter(bytes.NewReader(data), 1024, 2)
+	var got []*importer.Idxbuf
+	for {
+		ibs, err := ss.NextBytes()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ibs...)
+	}
+	if len(got) != 6 {
+		t.Fatalf("%d chunks, expected 6", len(got))
+	}
+	for i, ib := range got {
+		if ib.Idx != i {
+			t.Fatalf("chunk %d has index %d", i, ib.Idx)
+		}
+		// a chunk kept by the caller does not share memory with the next one
+		if cap(ib.Buf) != len(ib.Buf) {
+			t.Fatalf("chunk %d: %d bytes in a buffer of %d", i, len(ib.Buf), cap(ib.Buf))
+		}
+		end := (i + 1) * 1024
+		if end > len(data) {
+			end = len(data)
+		}
+		if !bytes.Equal(ib.Buf, data[i*1024:end]) {
+			t.Fatalf("chunk %d differs", i)
+		}
+	}
+}
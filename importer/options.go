@@ -0,0 +1,33 @@
+package importer
+
+import (
+	"github.com/filedrive-team/filehelper/progress"
+)
+
+// Option configures the optional behaviours of BalanceNode and BalanceNodeAt.
+type Option func(*options)
+
+type options struct {
+	tracker    *progress.Tracker
+	checkpoint *checkpoint
+	// rebuild the leaves of the recorded chunks
+	verifyCheckpoint bool
+	batch            *batchConfig
+	index            *ChunkIndex
+}
+
+func buildOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithProgress reports the progress of the import to obs, path names the file
+// in the reported progress and size is -1 when unknown.
+func WithProgress(obs progress.Observer, path string, size int64) Option {
+	return func(o *options) {
+		o.tracker = progress.NewTracker(obs, path, size)
+	}
+}
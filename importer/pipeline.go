@@ -0,0 +1,108 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ChunkError is returned by the importers when a chunk fails to be read or stored.
+type ChunkError struct {
+	Idx    int
+	Offset int64
+	Err    error
+}
+
+func newChunkError(idx int, err error) *ChunkError {
+	return &ChunkError{
+		Idx:    idx,
+		Offset: int64(idx) * int64(UnixfsChunkSize),
+		Err:    err,
+	}
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d at offset %d: %v", e.Idx, e.Offset, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// pipeline runs the goroutines of one import. The first failure cancels the
+// context shared by all of them, and wait only returns once every goroutine
+// has exited, so nothing outlives the import.
+type pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	parent context.Context
+
+	wg    sync.WaitGroup
+	once  sync.Once
+	err   error
+	links chan IdxLink
+}
+
+func newPipeline(ctx context.Context) *pipeline {
+	cctx, cancel := context.WithCancel(ctx)
+	return &pipeline{
+		ctx:    cctx,
+		cancel: cancel,
+		parent: ctx,
+		links:  make(chan IdxLink),
+	}
+}
+
+// spawn starts fn in a goroutine, all of them must be spawned before collect is called.
+func (p *pipeline) spawn(fn func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		fn()
+	}()
+}
+
+// fail records err if it is the first one and tears the pipeline down.
+func (p *pipeline) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+// send hands a link to the collector, it returns false once the pipeline is cancelled.
+func (p *pipeline) send(lk IdxLink) bool {
+	select {
+	case p.links <- lk:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// collect passes every link sent to fn until all goroutines have exited,
+// then returns the first failure or the cancellation of the parent context.
+func (p *pipeline) collect(fn func(IdxLink)) error {
+	go func() {
+		p.wg.Wait()
+		close(p.links)
+	}()
+	for lk := range p.links {
+		fn(lk)
+	}
+	p.cancel()
+	if p.err != nil {
+		return p.err
+	}
+	return p.parent.Err()
+}
+
+// releaseChunk gives back the buffer and budget held by a chunk which is not stored.
+func releaseChunk(ib *Idxbuf, budget *MemoryBudget) {
+	if ib.pooled != nil {
+		putChunkBuf(ib.pooled)
+		ib.pooled = nil
+		ib.Buf = nil
+	}
+	budget.release()
+}
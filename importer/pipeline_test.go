@@ -0,0 +1,149 @@
+package importer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/filedrive-team/filehelper/importer"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+
+	pb "github.com/ipfs/go-unixfs/pb"
+)
+
+var errStore = errors.New("store failed")
+
+// failingDAG fails to store the block fail, alone or in a batch,
+// and waits delay before every store.
+type failingDAG struct {
+	discardDAG
+	fail  cid.Cid
+	delay time.Duration
+}
+
+func (d *failingDAG) Add(ctx context.Context, nd format.Node) error {
+	return d.AddMany(ctx, []format.Node{nd})
+}
+
+func (d *failingDAG) AddMany(ctx context.Context, nds []format.Node) error {
+	if d.delay > 0 {
+		select {
+		case <-time.After(d.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, nd := range nds {
+		if nd.Cid().Equals(d.fail) {
+			return errStore
+		}
+	}
+	return nil
+}
+
+type importFunc func(ctx context.Context, data []byte, ds format.DAGService, opts ...importer.Option) (cid.Cid, error)
+
+var importers = []struct {
+	name string
+	run  importFunc
+}{
+	{"BalanceNode", func(ctx context.Context, data []byte, ds format.DAGService, opts ...importer.Option) (cid.Cid, error) {
+		return importer.BalanceNode(ctx, bytes.NewReader(data), int64(len(data)), ds, builder(), 4, opts...)
+	}},
+	{"BalanceNodeAt", func(ctx context.Context, data []byte, ds format.DAGService, opts ...importer.Option) (cid.Cid, error) {
+		return importer.BalanceNodeAt(ctx, bytes.NewReader(data), int64(len(data)), ds, builder(), 4, opts...)
+	}},
+}
+
+func builder() cid.Builder {
+	b, _ := merkledag.PrefixForCidVersion(1)
+	return b
+}
+
+// testData gives 40 chunks of data, each one distinct, and the cid of the leaf of chunk idx.
+func testData(t *testing.T, idx int) ([]byte, cid.Cid) {
+	t.Helper()
+	data := make([]byte, 40*chunk)
+	rand.New(rand.NewSource(1)).Read(data)
+	nd, err := importer.NewDagWithData(data[int64(idx)*chunk:int64(idx+1)*chunk], pb.Data_File, builder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, nd.Cid()
+}
+
+// settle waits for the goroutines started since baseline was taken to exit.
+func settle(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left, baseline %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPipelineStoreFailure(t *testing.T) {
+	const failIdx = 13
+	data, fail := testData(t, failIdx)
+	batch := importer.WithBatchWrite(4, 0, 0)
+	for _, imp := range importers {
+		for _, batched := range []bool{false, true} {
+			var opts []importer.Option
+			if batched {
+				opts = append(opts, batch)
+			}
+			baseline := runtime.NumGoroutine()
+			_, err := imp.run(context.Background(), data, &failingDAG{fail: fail}, opts...)
+			var ce *importer.ChunkError
+			if !errors.As(err, &ce) {
+				t.Fatalf("%s batched %v: expected a chunk error, got %v", imp.name, batched, err)
+			}
+			if !errors.Is(err, errStore) {
+				t.Fatalf("%s batched %v: store error not wrapped: %v", imp.name, batched, err)
+			}
+			// a batch reports its first leaf, leaves are batched as they are built
+			if batched {
+				if ce.Idx < 0 || ce.Idx >= 40 {
+					t.Fatalf("%s batched: chunk %d failed, out of the file", imp.name, ce.Idx)
+				}
+			} else if ce.Idx != failIdx {
+				t.Fatalf("%s: chunk %d failed, expected %d", imp.name, ce.Idx, failIdx)
+			}
+			if ce.Offset != int64(ce.Idx)*chunk {
+				t.Fatalf("%s batched %v: chunk %d at offset %d", imp.name, batched, ce.Idx, ce.Offset)
+			}
+			settle(t, baseline)
+		}
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	data, _ := testData(t, 0)
+	for _, imp := range importers {
+		baseline := runtime.NumGoroutine()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := imp.run(ctx, data, &failingDAG{}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s: expected cancellation, got %v", imp.name, err)
+		}
+		settle(t, baseline)
+
+		// cancelled while leaves are being stored
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := imp.run(ctx, data, &failingDAG{delay: 20 * time.Millisecond})
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: expected the deadline, got %v", imp.name, err)
+		}
+		settle(t, baseline)
+	}
+}
@@ -0,0 +1,114 @@
+package importer
+
+import (
+	"context"
+	"sync"
+)
+
+// chunk buffers are reused across leaves and files
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, UnixfsChunkSize)
+		return &buf
+	},
+}
+
+func getChunkBuf() *[]byte {
+	return chunkPool.Get().(*[]byte)
+}
+
+func putChunkBuf(buf *[]byte) {
+	*buf = (*buf)[:cap(*buf)]
+	chunkPool.Put(buf)
+}
+
+// MemoryBudget bounds the chunk data held in memory by all imports sharing it.
+// A chunk is charged when it is read and released once its leaf is stored,
+// so readers pause as soon as the dag writing side falls behind.
+type MemoryBudget struct {
+	tokens chan struct{}
+}
+
+// NewMemoryBudget returns a budget of limit bytes, rounded down to whole chunks
+// with a minimum of one chunk.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	n := limit / int64(UnixfsChunkSize)
+	if n < 1 {
+		n = 1
+	}
+	return &MemoryBudget{
+		tokens: make(chan struct{}, n),
+	}
+}
+
+// Limit is the number of bytes the budget allows.
+func (b *MemoryBudget) Limit() int64 {
+	return int64(cap(b.tokens)) * int64(UnixfsChunkSize)
+}
+
+// InUse is the number of bytes currently charged.
+func (b *MemoryBudget) InUse() int64 {
+	return int64(len(b.tokens)) * int64(UnixfsChunkSize)
+}
+
+func (b *MemoryBudget) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b.tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryAcquire charges a chunk if the budget allows it without waiting.
+func (b *MemoryBudget) tryAcquire() bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.tokens <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// exhausted reports whether every chunk of the budget is charged.
+func (b *MemoryBudget) exhausted() bool {
+	return b != nil && len(b.tokens) == cap(b.tokens)
+}
+
+func (b *MemoryBudget) release() {
+	if b == nil {
+		return
+	}
+	<-b.tokens
+}
+
+var (
+	budgetLk     sync.RWMutex
+	globalBudget *MemoryBudget
+)
+
+// SetMemoryBudget sets the budget shared by every import started afterwards,
+// e.g. all the files dataset.Import works on in parallel.
+// A limit <= 0 removes it, each import then holds at most batchReadNum+1 chunks,
+// one per worker and the one being read.
+func SetMemoryBudget(limit int64) {
+	budgetLk.Lock()
+	defer budgetLk.Unlock()
+	if limit <= 0 {
+		globalBudget = nil
+		return
+	}
+	globalBudget = NewMemoryBudget(limit)
+}
+
+func currentBudget() *MemoryBudget {
+	budgetLk.RLock()
+	defer budgetLk.RUnlock()
+	return globalBudget
+}
@@ -0,0 +1,147 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// number of chunks a worker reads in a row before taking the next region
+const regionChunks = 16
+
+// BalanceNodeAt is the same as BalanceNode for sources with random access,
+// workers read disjoint chunk aligned regions of r concurrently instead of
+// sharing a single sequential reader. fsize must be the exact size of the data,
+// the root cid is the one BalanceNode gives for the same content.
+// Failures and cancellation are handled as in BalanceNode.
+func BalanceNodeAt(ctx context.Context, r io.ReaderAt, fsize int64, bufDs format.DAGService, cidBuilder cid.Builder, workers int, opts ...Option) (root cid.Cid, err error) {
+	o := buildOptions(opts)
+	defer func() {
+		o.tracker.Finish(root, err)
+	}()
+	if fsize < 0 {
+		return cid.Undef, xerrors.Errorf("invalid file size: %d", fsize)
+	}
+	if fsize == 0 {
+		root, err = emptyFileNode(ctx, bufDs, cidBuilder)
+		if err == nil {
+			o.index.fill(root, nil)
+		}
+		return root, err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	budget := currentBudget()
+	chunkSize := int64(UnixfsChunkSize)
+	chunkNum := dataLinkNum(fsize, chunkSize)
+	regionNum := (chunkNum + regionChunks - 1) / regionChunks
+	dataLinks := make([]*linkAndSize, chunkNum)
+	if cp := o.checkpoint; cp != nil {
+		if err := cp.open(ctx, fsize, cidBuilder, bufDs); err != nil {
+			return cid.Undef, err
+		}
+		defer func() {
+			if cerr := cp.close(err == nil); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+	p := newPipeline(ctx)
+	lw := newLeafWriter(p, bufDs, o, workers, budget)
+
+	var nextRegion int64 = -1
+	for i := 0; i < workers; i++ {
+		p.spawn(func() {
+			defer lw.workerDone()
+			for {
+				region := int(atomic.AddInt64(&nextRegion, 1))
+				if region >= regionNum {
+					return
+				}
+				for idx := region * regionChunks; idx < (region+1)*regionChunks && idx < chunkNum; idx++ {
+					if l, ok := o.checkpoint.get(idx); ok && !o.verifyCheckpoint {
+						o.tracker.Read(int(l.FileSize))
+						if !p.send(IdxLink{Idx: idx, Link: l.Link, FileSize: l.FileSize}) {
+							return
+						}
+						continue
+					}
+					ib, err := readChunkAt(p.ctx, r, idx, fsize, budget)
+					if err != nil {
+						if p.ctx.Err() == nil {
+							p.fail(newChunkError(idx, err))
+						}
+						return
+					}
+					o.tracker.Read(len(ib.Buf))
+					nd, lk, err := buildLeaf(ib, cidBuilder, budget)
+					if err != nil {
+						p.fail(newChunkError(idx, err))
+						return
+					}
+					if err := lw.put(nd, lk); err != nil {
+						p.fail(err)
+						return
+					}
+					if p.ctx.Err() != nil {
+						return
+					}
+				}
+			}
+		})
+	}
+	if err := p.collect(func(lk IdxLink) {
+		dataLinks[lk.Idx] = &linkAndSize{
+			Link:     lk.Link,
+			FileSize: lk.FileSize,
+		}
+	}); err != nil {
+		return cid.Undef, err
+	}
+	for _, l := range dataLinks {
+		if l == nil {
+			return cid.Undef, xerrors.New("unexpected data links")
+		}
+	}
+	root, err = buildCidByLinks(ctx, dataLinks, bufDs, cidBuilder)
+	if err != nil {
+		return cid.Undef, err
+	}
+	o.index.fill(root, dataLinks)
+	return root, nil
+}
+
+// readChunkAt reads chunk idx of r into a pooled buffer charged to budget.
+func readChunkAt(ctx context.Context, r io.ReaderAt, idx int, fsize int64, budget *MemoryBudget) (*Idxbuf, error) {
+	if err := budget.acquire(ctx); err != nil {
+		return nil, err
+	}
+	off := int64(idx) * int64(UnixfsChunkSize)
+	size := int64(UnixfsChunkSize)
+	if off+size > fsize {
+		size = fsize - off
+	}
+	buf := getChunkBuf()
+	n, err := r.ReadAt((*buf)[:size], off)
+	if int64(n) == size {
+		err = nil
+	}
+	if err != nil {
+		putChunkBuf(buf)
+		budget.release()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &Idxbuf{
+		Idx:    idx,
+		Buf:    (*buf)[:size],
+		pooled: buf,
+	}, nil
+}
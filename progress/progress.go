@@ -0,0 +1,163 @@
+// Package progress reports the progress of imports to the caller.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Observer receives the progress of file imports,
+// implementations must be safe for concurrent use.
+type Observer interface {
+	// FileStarted is called before a file is read, size is -1 when unknown.
+	FileStarted(path string, size int64)
+	// ChunkStored is called each time a leaf of the file has been stored.
+	ChunkStored(p FileProgress)
+	// FileFinished is called once the file is imported or has failed.
+	FileFinished(p FileProgress, root cid.Cid, err error)
+}
+
+// DatasetObserver can be implemented by an Observer passed to dataset imports
+// to also receive the totals of the dataset after each file.
+type DatasetObserver interface {
+	Observer
+	DatasetProgress(p DatasetProgress)
+}
+
+type FileProgress struct {
+	Path string
+	// Size is the size of the file, -1 when unknown
+	Size         int64
+	BytesRead    int64
+	ChunksStored int64
+	Elapsed      time.Duration
+	// Throughput in bytes read per second since the file was started
+	Throughput float64
+}
+
+type DatasetProgress struct {
+	// TotalFiles and TotalSize keep growing while the targets are being walked
+	TotalFiles    uint64
+	TotalSize     uint64
+	ImportedFiles uint64
+	ImportedSize  uint64
+	Elapsed       time.Duration
+	// Throughput in bytes imported per second since the dataset was started
+	Throughput float64
+}
+
+// Tracker collects the progress of one file and forwards it to an Observer.
+// A Tracker over a nil Observer does nothing.
+type Tracker struct {
+	obs    Observer
+	path   string
+	size   int64
+	start  time.Time
+	read   int64
+	chunks int64
+}
+
+func NewTracker(obs Observer, path string, size int64) *Tracker {
+	t := &Tracker{
+		obs:   obs,
+		path:  path,
+		size:  size,
+		start: time.Now(),
+	}
+	if obs != nil {
+		obs.FileStarted(path, size)
+	}
+	return t
+}
+
+// Read records n more bytes read from the file.
+func (t *Tracker) Read(n int) {
+	if t == nil || t.obs == nil {
+		return
+	}
+	atomic.AddInt64(&t.read, int64(n))
+}
+
+// ChunkStored records a stored leaf and notifies the observer.
+func (t *Tracker) ChunkStored() {
+	if t == nil || t.obs == nil {
+		return
+	}
+	atomic.AddInt64(&t.chunks, 1)
+	t.obs.ChunkStored(t.Progress())
+}
+
+// Finish notifies the observer the file is done.
+func (t *Tracker) Finish(root cid.Cid, err error) {
+	if t == nil || t.obs == nil {
+		return
+	}
+	t.obs.FileFinished(t.Progress(), root, err)
+}
+
+func (t *Tracker) Progress() FileProgress {
+	elapsed := time.Since(t.start)
+	read := atomic.LoadInt64(&t.read)
+	return FileProgress{
+		Path:         t.path,
+		Size:         t.size,
+		BytesRead:    read,
+		ChunksStored: atomic.LoadInt64(&t.chunks),
+		Elapsed:      elapsed,
+		Throughput:   rate(uint64(read), elapsed),
+	}
+}
+
+// rate is the throughput of n bytes over elapsed in bytes per second.
+func rate(n uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / elapsed.Seconds()
+}
+
+// NewDatasetProgress computes the elapsed time and throughput of a dataset import started at start.
+func NewDatasetProgress(totalFiles, totalSize, importedFiles, importedSize uint64, start time.Time) DatasetProgress {
+	elapsed := time.Since(start)
+	return DatasetProgress{
+		TotalFiles:    totalFiles,
+		TotalSize:     totalSize,
+		ImportedFiles: importedFiles,
+		ImportedSize:  importedSize,
+		Elapsed:       elapsed,
+		Throughput:    rate(importedSize, elapsed),
+	}
+}
+
+// Printer is a DatasetObserver writing the dataset totals as text lines,
+// the file and chunk progress is not printed.
+type Printer struct {
+	lk sync.Mutex
+	w  io.Writer
+}
+
+// NewPrinter returns a Printer writing to w.
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{w: w}
+}
+
+func (*Printer) FileStarted(string, int64) {}
+
+func (*Printer) ChunkStored(FileProgress) {}
+
+func (*Printer) FileFinished(FileProgress, cid.Cid, error) {}
+
+func (p *Printer) DatasetProgress(dp DatasetProgress) {
+	if dp.TotalSize == 0 {
+		return
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	fmt.Fprintf(p.w, "total %d files, imported %d files, %.2f %%\n", dp.TotalFiles, dp.ImportedFiles, float64(dp.ImportedFiles)/float64(dp.TotalFiles)*100)
+	fmt.Fprintf(p.w, "total size: %d, imported size: %d, %.2f %%\n", dp.TotalSize, dp.ImportedSize, float64(dp.ImportedSize)/float64(dp.TotalSize)*100)
+}
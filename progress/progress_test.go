@@ -0,0 +1,114 @@
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+type recorder struct {
+	lk       sync.Mutex
+	started  []string
+	stored   []FileProgress
+	finished []FileProgress
+	root     cid.Cid
+	err      error
+}
+
+func (r *recorder) FileStarted(path string, size int64) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.started = append(r.started, path)
+}
+
+func (r *recorder) ChunkStored(p FileProgress) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.stored = append(r.stored, p)
+}
+
+func (r *recorder) FileFinished(p FileProgress, root cid.Cid, err error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.finished = append(r.finished, p)
+	r.root, r.err = root, err
+}
+
+func TestTracker(t *testing.T) {
+	r := &recorder{}
+	tr := NewTracker(r, "file", 300)
+	if len(r.started) != 1 || r.started[0] != "file" {
+		t.Fatalf("started %v", r.started)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tr.Read(100)
+			tr.ChunkStored()
+		}()
+	}
+	wg.Wait()
+	if len(r.stored) != 3 {
+		t.Fatalf("%d chunk notifications, expected 3", len(r.stored))
+	}
+	failed := errors.New("failed")
+	tr.Finish(cid.Undef, failed)
+	if len(r.finished) != 1 || r.err != failed {
+		t.Fatalf("finished %v with %v", r.finished, r.err)
+	}
+	p := r.finished[0]
+	if p.Path != "file" || p.Size != 300 || p.BytesRead != 300 || p.ChunksStored != 3 {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+	if p.Elapsed <= 0 || p.Throughput <= 0 {
+		t.Fatalf("no elapsed time or throughput in %+v", p)
+	}
+}
+
+func TestTrackerNil(t *testing.T) {
+	// neither a nil Tracker nor one without observer report anything
+	var tr *Tracker
+	tr.Read(1)
+	tr.ChunkStored()
+	tr.Finish(cid.Undef, nil)
+	tr = NewTracker(nil, "file", 1)
+	tr.Read(1)
+	tr.ChunkStored()
+	tr.Finish(cid.Undef, nil)
+	if p := tr.Progress(); p.BytesRead != 0 || p.ChunksStored != 0 {
+		t.Fatalf("progress counted without observer: %+v", p)
+	}
+}
+
+func TestDatasetProgress(t *testing.T) {
+	p := NewDatasetProgress(4, 400, 1, 100, time.Now().Add(-time.Second))
+	if p.Elapsed < time.Second {
+		t.Fatalf("elapsed %s", p.Elapsed)
+	}
+	if p.Throughput <= 0 || p.Throughput > 100 {
+		t.Fatalf("throughput %f, expected at most 100 bytes per second", p.Throughput)
+	}
+	if rate(100, 0) != 0 {
+		t.Fatal("rate over no time")
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.DatasetProgress(DatasetProgress{})
+	if buf.Len() != 0 {
+		t.Fatalf("printed before the totals are known: %q", buf.String())
+	}
+	p.DatasetProgress(DatasetProgress{TotalFiles: 4, TotalSize: 400, ImportedFiles: 1, ImportedSize: 100})
+	want := "total 4 files, imported 1 files, 25.00 %\ntotal size: 400, imported size: 100, 25.00 %\n"
+	if buf.String() != want {
+		t.Fatalf("printed %q, expected %q", buf.String(), want)
+	}
+}